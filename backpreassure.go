@@ -2,13 +2,12 @@ package backpressure
 
 import (
 	"fmt"
-	"log"
 	"math"
+	"math/rand/v2"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"github.com/HdrHistogram/hdrhistogram-go"
 )
 
 type Config struct {
@@ -21,10 +20,25 @@ type Config struct {
 	ThresholdPercent float64
 
 	// IncreasePercent defines an increase percent of current capacity.
+	// It is required by IncreaseMultiplicative and RateAdaptive only.
 	IncreasePercent float64
+	// IncreaseFunc defines how capacity grows. Default IncreaseMultiplicative
+	IncreaseFunc IncreaseFunc
+	// IncreaseStep defines the step of IncreaseAdditive.
+	IncreaseStep int64
+	// IncreaseSqrtFactor scales the step of IncreaseSqrt. Default 1
+	IncreaseSqrtFactor float64
+	// IncreaseCubicC defines the scaling constant C of IncreaseCubic. Default 0.4
+	IncreaseCubicC float64
 
 	// DecreasePercent defines an decrease percent of current capacity.
 	DecreasePercent float64
+	// DecreaseProportional scales the decrease by how far congestion is above ThresholdPercent,
+	// or latency above DecreaseLatency: from DecreaseMinPercent at the threshold up to DecreasePercent
+	// at full congestion or twice the latency. Optional
+	DecreaseProportional bool
+	// DecreaseMinPercent defines the smallest proportional decrease. Optional
+	DecreaseMinPercent float64
 
 	// MaxMax defines a maximum possible capacity. Default math.MaxInt64
 	MaxMax int64
@@ -41,20 +55,154 @@ type Config struct {
 	// DecreaseLatency The capacity is decreased if latency goes above the value at given percentile
 	DecreaseLatency           time.Duration
 	DecreaseLatencyPercentile float64
+
+	// NewLatencySketch creates sketches latencies are recorded into. Default NewHDRSketch
+	NewLatencySketch func() LatencySketch `json:"-"`
+	// LatencyShards spreads latency recording over that many sketches, rounded up to a power of two,
+	// so concurrent Release calls rarely wait for each other. Each shard keeps 3 sketches.
+	// Default GOMAXPROCS up to 8, Hierarchy children default to 1
+	LatencyShards int
+
+	// LatencySampleEvery records the latency of one in N requests on average. Default 1, every request is recorded
+	LatencySampleEvery int64
+	// LatencySamplesPerPeriod adapts the sampling probability so that roughly the given number of latencies
+	// is recorded per DecidePeriod. The probability is derived from the traffic of the previous period.
+	LatencySamplesPerPeriod int64
+
+	// Cluster shares congestion signals with other replicas, see NewCluster. A Cluster serves one limiter only. Optional
+	Cluster *Cluster `json:"-"`
+
+	// Clock provides time, for example a ManualClock to run the limiter in simulated time. Default real time
+	Clock Clock `json:"-"`
+
+	// Rate limits requests per second on top of the concurrency limit. Optional
+	Rate float64
+	// RateBurst defines how many requests may come at once above the Rate. Default 1
+	RateBurst int64
+	// RateAdaptive lets decisions change the rate the same way as max: it is increased by IncreasePercent
+	// and decreased by DecreasePercent. The rate never goes above Rate and below RateMin.
+	RateAdaptive bool
+	// RateMin defines a minimum possible rate when RateAdaptive is set. Default 1
+	RateMin float64
+
+	// QueueSize defines how many AcquireWait callers may wait for capacity. Default 0, callers are not queued
+	QueueSize int
+	// QueueTarget defines an acceptable time spent in the queue. Default 5ms
+	QueueTarget time.Duration
+	// QueueInterval defines a period the minimum time spent in the queue is tracked over. Default 100ms
+	QueueInterval time.Duration
+
+	// BreakerPeriods enables the circuit breaker. It opens after the given number of consecutive periods
+	// in which all requests are congested, and denies all requests while open. Optional
+	BreakerPeriods int
+	// BreakerCooldown defines for how long the breaker stays open before probe requests are let through. Default 10 * DecidePeriod
+	BreakerCooldown time.Duration
+	// BreakerProbes defines how many probe requests must succeed to close the breaker. Default 1
+	BreakerProbes int64
+
+	// ThrottleK switches admission to client-side adaptive throttling: instead of denying everything above max,
+	// Acquire denies with probability max(0, (requests - ThrottleK*accepts) / (requests + 1)),
+	// where requests and accepts are counted over ThrottleWindow. Accepts are successful requests.
+	// Lower values throttle more aggressively, 2 is a common choice. As max is not enforced, priority reserves,
+	// partitions, warm-up, early drop and queueing cannot be configured together with it and tenant shares are not enforced. Optional
+	ThrottleK float64
+	// ThrottleWindow defines for how long requests and accepts are counted. It is rounded up to whole DecidePeriods. Default 2m
+	ThrottleWindow time.Duration
+
+	// EarlyDropPercent defines a fraction of max above which Acquire starts denying requests at random.
+	// The probability rises linearly from 0 at the fraction to 1 at max, so callers do not all hit the limit at the same moment. Optional
+	EarlyDropPercent float64
+
+	// DefaultPriorityPercent defines a fraction of max requests of PriorityDefault may use, the rest is reserved for PriorityCritical. Default 1
+	DefaultPriorityPercent float64
+	// SheddablePriorityPercent defines a fraction of max requests of PrioritySheddable may use. Default DefaultPriorityPercent
+	SheddablePriorityPercent float64
+
+	// TenantReservePercent defines a fraction of max AcquireTenant does not lend to tenants above their share,
+	// so a tenant returning to its share gets capacity without waiting for borrowers to finish. Default 0.1
+	TenantReservePercent float64
+	// TenantStatsTop defines how many tenants with the most used capacity are reported in AIMDStats.Tenants. Default 10
+	TenantStatsTop int
+
+	// Partitions split max into named partitions for AcquirePartition. The value is a fraction of max guaranteed to the partition,
+	// the fractions must not add up to more than one. A partition may burst into capacity other partitions do not use. Optional
+	Partitions map[string]float64
+
+	// WarmUp defines for how long after New or WarmUp the effective max ramps from MinMax up to max.
+	// Decide keeps adjusting max as usual, only admission is limited by the ramp. Optional
+	WarmUp time.Duration
+	// WarmUpCurve defines the shape of the ramp. Default WarmUpLinear
+	WarmUpCurve WarmUpCurve
+
+	// DecisionHistorySize defines how many recent decisions are kept for DecisionHistory. Default 64, -1 disables the history
+	DecisionHistorySize int
 }
 
 type AIMDStats struct {
-	Max  int64
-	Used int64
+	Max     int64
+	Used    int64
+	UsedMax int64
+
+	MaxMax int64
+	MaxMin int64
 
-	MaxMax                int64
-	MaxMin                int64
+	// Counters are cumulative and include the period in progress.
 	SuccessfulCounter     int64
 	CongestedCounter      int64
 	DeniedCounter         int64
 	DecideIncreaseCounter int64
 	DecideDecreaseCounter int64
 	DecideSameCounter     int64
+	// Rate is the current rate limit in requests per second. It is zero if Rate is not configured.
+	Rate float64
+
+	// Queued is the number of AcquireWait callers waiting for capacity.
+	// QueueOverloaded is set while the queue is standing and served in LIFO order.
+	Queued          int64
+	QueueOverloaded bool
+
+	// EarlyDropProbability is the current probability of denying a request by early drop.
+	EarlyDropProbability float64
+
+	// ThrottleProbability is the current probability of denying a request by adaptive throttling.
+	ThrottleProbability float64
+
+	// BreakerState is the circuit breaker state. It is empty if the breaker is not configured.
+	BreakerState BreakerState
+
+	// Priorities break used capacity and counters down by Priority.
+	Priorities map[Priority]PriorityStats
+
+	// Tenants are the top TenantStatsTop tenants of AcquireTenant by used capacity.
+	Tenants []TenantStats
+
+	// Partitions break used capacity and counters down by Config.Partitions.
+	Partitions map[string]PartitionStats
+
+	// EffectiveMax is max limited by the warm-up ramp. It equals Max once the ramp is over.
+	EffectiveMax int64
+
+	// DeniedReasons breaks DeniedCounter down by DenyReason. It includes the period in progress.
+	DeniedReasons map[DenyReason]int64
+
+	// PeriodSuccessful, PeriodCongested and PeriodDenied count requests of the period in progress.
+	PeriodSuccessful int64
+	PeriodCongested  int64
+	PeriodDenied     int64
+
+	// Throughput is the number of released requests per second and CongestedPercent is the local congestion ratio,
+	// both within the last complete period.
+	Throughput       float64
+	CongestedPercent float64
+
+	// Latencies are taken from the recorded latencies window. They are zero if latency is not tracked.
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
+
+	// LastDecideAt is the time of the last complete period.
+	LastDecideAt time.Time
 }
 
 func DefaultAIMDConfig() Config {
@@ -70,8 +218,10 @@ func DefaultAIMDConfig() Config {
 }
 
 type Backpreassure struct {
-	cfg Config
-	dt  *time.Ticker
+	cfg      Config
+	clock    Clock
+	dt       Ticker
+	decideCh <-chan time.Time
 
 	max        int64
 	used       int64
@@ -79,12 +229,46 @@ type Backpreassure struct {
 	denied     int64
 	successful int64
 	congested  int64
+	deniedBy   [denyReasonCount]int64
+	// prio counts requests of priorities other than PriorityDefault, which gets the rest of the totals
+	prio [priorityCount]priorityCounters
+	// prioritized is set if a priority may use less than the whole max
+	prioritized bool
 
 	stats    AIMDStats
 	muxStats sync.RWMutex
 
-	h    *hdrhistogram.WindowedHistogram
-	hMux sync.Mutex
+	decideMux     sync.Mutex
+	periodStartAt time.Time
+	pinned        int64
+
+	// lastDecreaseMax, lastDecreaseTo and lastDecreaseAt are the max the last decrease started from,
+	// the max it ended with and its time, used by IncreaseCubic
+	lastDecreaseMax int64
+	lastDecreaseTo  int64
+	lastDecreaseAt  time.Time
+
+	lat             *latencyShards
+	sampleThreshold uint64
+
+	rl *rateLimiter
+	q  *codelQueue
+	br *breaker
+	th *throttle
+	fs *fairShare
+
+	partitions      []*partition
+	partitionByName map[string]*partition
+
+	// warmUpEndAt is when the warm-up ramp ends in UnixNano format, zero once it is over
+	warmUpEndAt int64
+
+	decisions *decisionRing
+
+	closed    int32
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	doneCh    chan struct{}
 }
 
 func New(cfg Config) (*Backpreassure, error) {
@@ -99,49 +283,297 @@ func New(cfg Config) (*Backpreassure, error) {
 		cfg.MaxMax = math.MaxInt64
 	}
 	if cfg.Max == 0 {
-		cfg.Max = (cfg.MaxMax + cfg.MinMax) / 2
+		cfg.Max = cfg.MinMax + (cfg.MaxMax-cfg.MinMax)/2
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+	if cfg.Rate > 0 && cfg.RateBurst == 0 {
+		cfg.RateBurst = 1
+	}
+	if cfg.Rate > 0 && cfg.RateMin == 0 {
+		cfg.RateMin = math.Min(1, cfg.Rate)
+	}
+	if cfg.QueueSize > 0 && cfg.QueueTarget == 0 {
+		cfg.QueueTarget = time.Millisecond * 5
+	}
+	if cfg.QueueSize > 0 && cfg.QueueInterval == 0 {
+		cfg.QueueInterval = time.Millisecond * 100
+	}
+	if cfg.BreakerPeriods > 0 && cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = cfg.DecidePeriod * 10
+	}
+	if cfg.BreakerPeriods > 0 && cfg.BreakerProbes == 0 {
+		cfg.BreakerProbes = 1
+	}
+	if cfg.ThrottleK > 0 && cfg.ThrottleWindow == 0 {
+		cfg.ThrottleWindow = time.Minute * 2
+	}
+	if cfg.DefaultPriorityPercent == 0 {
+		cfg.DefaultPriorityPercent = 1
+	}
+	if cfg.SheddablePriorityPercent == 0 {
+		cfg.SheddablePriorityPercent = cfg.DefaultPriorityPercent
+	}
+	if cfg.TenantReservePercent == 0 {
+		cfg.TenantReservePercent = 0.1
+	}
+	if cfg.TenantStatsTop == 0 {
+		cfg.TenantStatsTop = 10
+	}
+	if cfg.WarmUp > 0 && cfg.WarmUpCurve == "" {
+		cfg.WarmUpCurve = WarmUpLinear
+	}
+	if cfg.IncreaseFunc == "" {
+		cfg.IncreaseFunc = IncreaseMultiplicative
+	}
+	if cfg.IncreaseFunc == IncreaseSqrt && cfg.IncreaseSqrtFactor == 0 {
+		cfg.IncreaseSqrtFactor = 1
+	}
+	if cfg.IncreaseFunc == IncreaseCubic && cfg.IncreaseCubicC == 0 {
+		cfg.IncreaseCubicC = 0.4
+	}
+	if cfg.DecisionHistorySize == 0 {
+		cfg.DecisionHistorySize = 64
+	}
+	if cfg.LatencyShards == 0 {
+		cfg.LatencyShards = min(runtime.GOMAXPROCS(0), defaultLatencyShards)
+	}
+	if cfg.NewLatencySketch == nil {
+		cfg.NewLatencySketch = func() LatencySketch {
+			return NewHDRSketch()
+		}
+	}
+
+	if cfg.Cluster != nil {
+		if err := cfg.Cluster.attach(cfg.Clock); err != nil {
+			return nil, fmt.Errorf("Cluster: %s", err)
+		}
 	}
 
 	bp := &Backpreassure{
-		cfg: cfg,
-		dt:  time.NewTicker(cfg.DecidePeriod),
+		cfg:   cfg,
+		clock: cfg.Clock,
+		dt:    cfg.Clock.NewTicker(cfg.DecidePeriod),
+
+		max:           cfg.Max,
+		periodStartAt: cfg.Clock.Now(),
+
+		lastDecreaseMax: cfg.Max,
+		lastDecreaseTo:  cfg.Max,
+		lastDecreaseAt:  cfg.Clock.Now(),
 
-		max: cfg.Max,
+		sampleThreshold: math.MaxUint64,
 
-		h: hdrhistogram.NewWindowed(3, 0, (time.Minute * 10).Nanoseconds(), 1),
+		fs: newFairShare(),
+
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	bp.decideCh = bp.dt.C()
+	bp.partitions, bp.partitionByName = newPartitions(cfg.Partitions)
+	bp.WarmUp()
+	if cfg.Rate > 0 {
+		bp.rl = newRateLimiter(cfg.Rate, cfg.RateMin, cfg.RateBurst)
+	}
+	if cfg.QueueSize > 0 {
+		bp.q = newCodelQueue(cfg.QueueSize, cfg.QueueTarget.Nanoseconds(), cfg.QueueInterval.Nanoseconds())
+	}
+	if cfg.BreakerPeriods > 0 {
+		bp.br = newBreaker(cfg.BreakerPeriods, cfg.BreakerCooldown.Nanoseconds(), cfg.BreakerProbes)
+	}
+	if cfg.ThrottleK > 0 {
+		periods := int((cfg.ThrottleWindow + cfg.DecidePeriod - 1) / cfg.DecidePeriod)
+		bp.th = newThrottle(cfg.ThrottleK, periods, cfg.DecidePeriod, cfg.Clock.Now())
+	}
+	bp.prioritized = cfg.DefaultPriorityPercent < 1 || cfg.SheddablePriorityPercent < 1
+	if cfg.DecisionHistorySize > 0 {
+		bp.decisions = newDecisionRing(cfg.DecisionHistorySize)
+	}
+	if cfg.LatencySampleEvery > 1 {
+		bp.sampleThreshold = sampleThreshold(1 / float64(cfg.LatencySampleEvery))
 	}
 
-	// TODO: shutdown
-	go func() {
-		t := time.NewTicker(time.Second * 10)
-		defer t.Stop()
+	if cfg.DecreaseLatencyPercentile > 0 || cfg.SameLatencyPercentile > 0 {
+		bp.lat = newLatencyShards(cfg.NewLatencySketch, cfg.LatencyShards)
 
-		for range t.C {
-			bp.hMux.Lock()
-			bp.h.Rotate()
-			bp.hMux.Unlock()
-		}
-	}()
+		go func() {
+			defer close(bp.doneCh)
+
+			t := bp.clock.NewTicker(time.Second * 10)
+			defer t.Stop()
+
+			for {
+				select {
+				case <-t.C():
+					bp.lat.rotate()
+				case <-bp.closeCh:
+					return
+				}
+			}
+		}()
+	} else {
+		close(bp.doneCh)
+	}
 
 	return bp, nil
 }
 
+// Close stops background work of the limiter. Acquire denies all requests with DenyReasonShutdown afterwards, queued waiters included.
+// Tokens acquired before Close can still be released.
+func (bp *Backpreassure) Close() error {
+	bp.closeOnce.Do(func() {
+		atomic.StoreInt32(&bp.closed, 1)
+		bp.dt.Stop()
+		close(bp.closeCh)
+
+		if bp.q != nil {
+			for _, w := range bp.q.close() {
+				t := deniedToken(atomic.LoadInt64(&bp.max), atomic.LoadInt64(&bp.used), DenyReasonShutdown)
+				bp.deny(t)
+				w.ch <- t
+			}
+		}
+	})
+	<-bp.doneCh
+
+	return nil
+}
+
 func (bp *Backpreassure) Acquire() (Token, bool) {
+	var t Token
+	allowed := bp.acquire(acquireReq{priority: PriorityDefault}, &t)
+	if !allowed {
+		bp.deny(t)
+	}
+
+	return t, allowed
+}
+
+type acquireReq struct {
+	priority Priority
+
+	// tenant is set by AcquireTenant, tenantFraction is the fraction of max the tenant is guaranteed.
+	tenant         *tenant
+	tenantFraction float64
+
+	// partition is set by AcquirePartition for known partitions.
+	partition *partition
+}
+
+// acquire takes capacity if available. Unlike Acquire it does not count denials, so the caller may still wait for capacity.
+// The token is written to t rather than returned, so the hot path does not copy it through every call.
+func (bp *Backpreassure) acquire(req acquireReq, t *Token) bool {
+	if atomic.LoadInt32(&bp.closed) == 1 {
+		*t = deniedToken(atomic.LoadInt64(&bp.max), atomic.LoadInt64(&bp.used), DenyReasonShutdown)
+		req.denied(t)
+		return false
+	}
+
 	select {
-	case <-bp.dt.C:
+	case <-bp.decideCh:
 		bp.decide()
 	default:
 	}
 
+	if !bp.checkLimits(req, t) {
+		req.denied(t)
+		return false
+	}
+
+	return true
+}
+
+// denied sets the priority, tenant and partition of the request on a denied token.
+func (req acquireReq) denied(t *Token) {
+	t.Priority = req.priority
+	if req.tenant != nil {
+		t.Tenant = req.tenant.key
+	}
+	if req.partition != nil {
+		t.Partition = req.partition.name
+	}
+}
+
+// checkLimits takes capacity if the breaker, max, priority, early drop, tenant, partition and rate limits allow it.
+// It is shared by Acquire and waiters served by dispatch.
+func (bp *Backpreassure) checkLimits(req acquireReq, t *Token) bool {
+	p := req.priority
+
+	var probe bool
+	if bp.br != nil {
+		var allowed bool
+		allowed, probe = bp.br.allow(bp.clock)
+		if !allowed {
+			*t = deniedToken(atomic.LoadInt64(&bp.max), atomic.LoadInt64(&bp.used), DenyReasonCircuitOpen)
+			return false
+		}
+	}
+
 	used := atomic.AddInt64(&bp.used, 1)
-	maxCap := atomic.LoadInt64(&bp.max)
-	if used > maxCap {
-		atomic.AddInt64(&bp.used, -1)
-		atomic.AddInt64(&bp.denied, 1)
-		return Token{
-			Max:  maxCap,
-			Used: used,
-		}, false
+	maxCap := bp.effectiveMax()
+	if bp.th != nil {
+		if !probe && bp.throttled() {
+			bp.rollback(probe, nil, nil)
+			*t = deniedToken(maxCap, used, DenyReasonThrottled)
+			return false
+		}
+	} else if used > maxCap {
+		bp.rollback(probe, nil, nil)
+		*t = deniedToken(maxCap, used, DenyReasonOverLimit)
+		return false
+	} else if bp.prioritized && p != PriorityCritical && used > bp.priorityLimit(p, maxCap) {
+		bp.rollback(probe, nil, nil)
+		*t = deniedToken(maxCap, used, DenyReasonPriority)
+		return false
+	} else if bp.cfg.EarlyDropPercent > 0 && !probe {
+		if p := bp.earlyDropProbability(used-1, maxCap); p > 0 && rand.Float64() < p {
+			bp.rollback(probe, nil, nil)
+			*t = deniedToken(maxCap, used, DenyReasonEarlyDrop)
+			return false
+		}
+	}
+
+	// takeTenant and takePartition give back their own slot when they deny
+	if req.tenant != nil && !bp.takeTenant(req.tenant, used, maxCap, req.tenantFraction) {
+		bp.rollback(probe, nil, nil)
+		*t = deniedToken(maxCap, used, DenyReasonTenantShare)
+		return false
+	}
+	if len(bp.partitions) > 0 && !bp.takePartition(req.partition, used, maxCap) {
+		bp.rollback(probe, req.tenant, nil)
+		*t = deniedToken(maxCap, used, DenyReasonPartition)
+		return false
+	}
+
+	return bp.admit(used, maxCap, probe, req, t)
+}
+
+// rollback gives back the capacity, the probe and the tenant and partition slots checkLimits took for a request it denies.
+func (bp *Backpreassure) rollback(probe bool, tn *tenant, pt *partition) {
+	atomic.AddInt64(&bp.used, -1)
+	if probe {
+		bp.br.cancelProbe()
+	}
+	if tn != nil {
+		atomic.AddInt64(&tn.used, -1)
+	}
+	if pt != nil {
+		atomic.AddInt64(&pt.used, -1)
+	}
+}
+
+// admit completes admission of a request which has already taken capacity and slots, or gives them back if the rate limit denies the request.
+func (bp *Backpreassure) admit(used, maxCap int64, probe bool, req acquireReq, t *Token) bool {
+	p := req.priority
+	var now int64
+	if bp.rl != nil {
+		now = bp.clock.Now().UnixNano()
+		if !bp.rl.allow(now) {
+			bp.rollback(probe, req.tenant, req.partition)
+			*t = deniedToken(maxCap, used, DenyReasonRateLimit)
+			return false
+		}
 	}
 
 loop:
@@ -156,82 +588,395 @@ loop:
 		}
 	}
 
+	if pc := bp.priorityCounters(p); pc != nil {
+		atomic.AddInt64(&pc.used, 1)
+	}
+
+	if now == 0 {
+		now = bp.clock.Now().UnixNano()
+	}
+
+	var samples int64
+	if bp.lat != nil {
+		samples = bp.sampleLatency()
+	}
+
+	var tenantKey, partitionName string
+	if req.tenant != nil {
+		tenantKey = req.tenant.key
+	}
+	if req.partition != nil {
+		partitionName = req.partition.name
+	}
+
+	*t = Token{
+		Max:       maxCap,
+		Used:      used,
+		StartAt:   now,
+		Priority:  p,
+		Probe:     probe,
+		samples:   samples,
+		Tenant:    tenantKey,
+		tenant:    req.tenant,
+		Partition: partitionName,
+		partition: req.partition,
+	}
+
+	return true
+}
+
+func deniedToken(maxCap, used int64, reason DenyReason) Token {
 	return Token{
-		Max:     maxCap,
-		Used:    used,
-		StartAt: time.Now().UnixNano(),
-	}, true
+		Max:        maxCap,
+		Used:       used,
+		Denied:     true,
+		DenyReason: reason,
+	}
 }
 
+// Release returns the capacity taken by the token. Releasing a denied token does nothing.
 func (bp *Backpreassure) Release(t Token) {
+	if t.Denied {
+		return
+	}
+
 	atomic.AddInt64(&bp.used, -1)
 
+	if t.Probe {
+		bp.br.release(t.Congested, bp.clock.Now().UnixNano())
+	}
+
 	if !t.Congested {
 		atomic.AddInt64(&bp.successful, 1)
 	} else {
 		atomic.AddInt64(&bp.congested, 1)
 	}
 
-	if bp.cfg.DecreaseLatencyPercentile > 0 || bp.cfg.SameLatencyPercentile > 0 {
+	if pc := bp.priorityCounters(t.Priority); pc != nil {
+		atomic.AddInt64(&pc.used, -1)
+		if !t.Congested {
+			atomic.AddInt64(&pc.successful, 1)
+		} else {
+			atomic.AddInt64(&pc.congested, 1)
+		}
+	}
+
+	if t.tenant != nil {
+		t.tenant.release(t.Congested)
+	}
+	if t.partition != nil {
+		t.partition.release(t.Congested)
+	}
+
+	// waiters are served once the per-class counters are updated, so they see the limits as Acquire would
+	if bp.q != nil && bp.q.len() > 0 {
+		bp.dispatch()
+	}
+
+	if t.samples > 0 {
 		startT := time.Unix(0, t.StartAt)
-		dur := time.Now().Sub(startT).Nanoseconds()
+		bp.lat.record(bp.clock.Now().Sub(startT).Nanoseconds(), t.samples)
+	}
+}
 
-		if !t.Denied {
-			bp.hMux.Lock()
-			defer bp.hMux.Unlock()
-			if err := bp.h.Current.RecordValue(dur); err != nil {
-				log.Printf("[ERROR] backpressure: histogram: record value: %s", err)
-			}
-		}
+// Cancel gives back the capacity taken by the token without counting the request as successful or congested.
+// It is meant for requests which were not sent after all. Canceling a denied token does nothing.
+func (bp *Backpreassure) Cancel(t Token) {
+	if t.Denied {
+		return
+	}
+
+	bp.giveBack(t)
+	if bp.q != nil && bp.q.len() > 0 {
+		bp.dispatch()
 	}
 }
 
+// giveBack returns the capacity taken by an allowed token without counting the request.
+func (bp *Backpreassure) giveBack(t Token) {
+	atomic.AddInt64(&bp.used, -1)
+
+	if t.Probe {
+		bp.br.cancelProbe()
+	}
+
+	if pc := bp.priorityCounters(t.Priority); pc != nil {
+		atomic.AddInt64(&pc.used, -1)
+	}
+
+	if t.tenant != nil {
+		atomic.AddInt64(&t.tenant.used, -1)
+	}
+	if t.partition != nil {
+		atomic.AddInt64(&t.partition.used, -1)
+	}
+}
+
+// Stats returns live values: current capacity, counters including the period in progress and recent latencies.
 func (bp *Backpreassure) Stats() AIMDStats {
+	// decide moves period counts into cumulative counters, the lock keeps them from being seen twice or missed.
+	bp.decideMux.Lock()
 	bp.muxStats.RLock()
-	defer bp.muxStats.RUnlock()
-
 	s := bp.stats
+	s.PeriodSuccessful = atomic.LoadInt64(&bp.successful)
+	s.PeriodCongested = atomic.LoadInt64(&bp.congested)
+	s.PeriodDenied = atomic.LoadInt64(&bp.denied)
+	bp.muxStats.RUnlock()
+	bp.decideMux.Unlock()
+
+	s.Max = atomic.LoadInt64(&bp.max)
+	s.EffectiveMax = bp.effectiveMax()
 	s.Used = atomic.LoadInt64(&bp.used)
+	s.UsedMax = atomic.LoadInt64(&bp.usedMax)
+	s.MaxMax = bp.cfg.MaxMax
+	s.MaxMin = bp.cfg.MinMax
+	s.SuccessfulCounter += s.PeriodSuccessful
+	s.CongestedCounter += s.PeriodCongested
+	s.DeniedCounter += s.PeriodDenied
+	s.DeniedReasons = bp.deniedReasons()
+	s.Priorities = bp.priorityStats(s)
+	s.Tenants = bp.fs.stats(s.EffectiveMax, bp.cfg.TenantStatsTop)
+	s.Partitions = bp.partitionStats(s.EffectiveMax)
+	if bp.rl != nil {
+		s.Rate = bp.rl.rate()
+	}
+	if bp.cfg.EarlyDropPercent > 0 {
+		s.EarlyDropProbability = bp.earlyDropProbability(s.Used, s.EffectiveMax)
+	}
+	if bp.th != nil {
+		s.ThrottleProbability = bp.throttleProbability()
+	}
+	if bp.br != nil {
+		s.BreakerState = bp.br.currentState()
+	}
+	if bp.q != nil {
+		s.Queued = bp.q.len()
+		s.QueueOverloaded = bp.q.isOverloaded()
+	}
+
+	if bp.lat != nil {
+		qs := bp.lat.quantiles(0.5, 0.9, 0.99, 1)
+		s.LatencyP50 = time.Duration(qs[0])
+		s.LatencyP90 = time.Duration(qs[1])
+		s.LatencyP99 = time.Duration(qs[2])
+		s.LatencyMax = time.Duration(qs[3])
+	}
 
 	return s
 }
 
+// Config returns the config the limiter runs with, defaults included.
+func (bp *Backpreassure) Config() Config {
+	return bp.cfg
+}
+
+// Decide makes a decision on capacity right away instead of waiting for the end of the DecidePeriod.
+func (bp *Backpreassure) Decide() {
+	bp.decide()
+}
+
+// Pin fixes max at the given value. Decisions keep being made and counted but do not change max until Unpin is called.
+func (bp *Backpreassure) Pin(max int64) error {
+	if max <= 0 {
+		return fmt.Errorf("max: must be positive")
+	}
+
+	bp.decideMux.Lock()
+	defer bp.decideMux.Unlock()
+
+	atomic.StoreInt64(&bp.pinned, max)
+	atomic.StoreInt64(&bp.max, max)
+
+	return nil
+}
+
+// Unpin lets decisions change max again, starting from the pinned value.
+func (bp *Backpreassure) Unpin() {
+	bp.decideMux.Lock()
+	defer bp.decideMux.Unlock()
+
+	atomic.StoreInt64(&bp.pinned, 0)
+	bp.resetIncrease(atomic.LoadInt64(&bp.max))
+}
+
+// Pinned returns the pinned max or zero if max is not pinned.
+func (bp *Backpreassure) Pinned() int64 {
+	return atomic.LoadInt64(&bp.pinned)
+}
+
+// ResetStats zeroes cumulative counters and the maximum used capacity.
+// Counts of the period in progress are kept as the next decision is made on them.
+func (bp *Backpreassure) ResetStats() {
+	bp.decideMux.Lock()
+	defer bp.decideMux.Unlock()
+
+	atomic.StoreInt64(&bp.usedMax, 0)
+
+	bp.muxStats.Lock()
+	bp.stats.SuccessfulCounter = 0
+	bp.stats.CongestedCounter = 0
+	bp.stats.DeniedCounter = 0
+	bp.stats.DecideIncreaseCounter = 0
+	bp.stats.DecideDecreaseCounter = 0
+	bp.stats.DecideSameCounter = 0
+	bp.muxStats.Unlock()
+
+	for i := range bp.deniedBy {
+		atomic.StoreInt64(&bp.deniedBy[i], 0)
+	}
+	for i := range bp.prio {
+		atomic.StoreInt64(&bp.prio[i].successful, 0)
+		atomic.StoreInt64(&bp.prio[i].congested, 0)
+		atomic.StoreInt64(&bp.prio[i].denied, 0)
+	}
+	bp.fs.resetStats()
+	for _, p := range bp.partitions {
+		atomic.StoreInt64(&p.successful, 0)
+		atomic.StoreInt64(&p.congested, 0)
+		atomic.StoreInt64(&p.denied, 0)
+	}
+}
+
 func (bp *Backpreassure) decide() {
+	bp.decideMux.Lock()
+	defer bp.decideMux.Unlock()
+
+	if bp.q != nil {
+		// max might have grown
+		defer bp.dispatch()
+	}
+
 	successful := atomic.SwapInt64(&bp.successful, 0)
 	congested := atomic.SwapInt64(&bp.congested, 0)
 	denied := atomic.SwapInt64(&bp.denied, 0)
 	max := atomic.LoadInt64(&bp.max)
 
+	now := bp.clock.Now()
+	if bp.th != nil {
+		bp.th.period(successful+congested+denied, successful, now)
+	}
+	bp.fs.nextPeriod()
+
+	var peerSuccessful, peerCongested int64
+	if bp.cfg.Cluster != nil {
+		peerSuccessful, peerCongested = bp.cfg.Cluster.exchange(successful, congested, max, bp.cfg.DecidePeriod*3)
+	}
+
+	var throughput, localCongestedPercent float64
+	if d := now.Sub(bp.periodStartAt).Seconds(); d > 0 {
+		throughput = float64(successful+congested) / d
+	}
+	if successful+congested > 0 {
+		localCongestedPercent = float64(congested) / float64(successful+congested)
+	}
+	bp.periodStartAt = now
+
 	if successful+congested == 0 {
+		bp.muxStats.Lock()
+		bp.stats.DeniedCounter += denied
+		bp.stats.Throughput = 0
+		bp.stats.CongestedPercent = 0
+		bp.stats.LastDecideAt = now
+		bp.muxStats.Unlock()
+
 		return
 	}
 
-	highLatency := false
-	moderateLatency := false
-	if bp.cfg.DecreaseLatencyPercentile > 0 || bp.cfg.SameLatencyPercentile > 0 {
-		bp.hMux.Lock()
-		mh := bp.h.Merge()
+	if bp.br != nil {
+		bp.br.period(successful, congested, now.UnixNano())
+	}
+
+	if bp.cfg.LatencySamplesPerPeriod > 0 {
+		p := float64(bp.cfg.LatencySamplesPerPeriod) / float64(successful+congested)
+		atomic.StoreUint64(&bp.sampleThreshold, sampleThreshold(p))
+	}
 
-		highLatency = mh.ValueAtPercentile(bp.cfg.DecreaseLatencyPercentile*100) > bp.cfg.DecreaseLatency.Nanoseconds()
-		moderateLatency = bp.cfg.SameLatency > 0 && mh.ValueAtPercentile(bp.cfg.SameLatencyPercentile*100) > bp.cfg.SameLatency.Nanoseconds()
-		bp.hMux.Unlock()
+	var sameLatency, decreaseLatency int64
+	if bp.lat != nil {
+		bp.lat.merge(func(ms LatencySketch) {
+			if bp.cfg.DecreaseLatency > 0 {
+				decreaseLatency = ms.Quantile(bp.cfg.DecreaseLatencyPercentile)
+			}
+			if bp.cfg.SameLatency > 0 {
+				sameLatency = ms.Quantile(bp.cfg.SameLatencyPercentile)
+			}
+		})
 	}
+	highLatency := bp.cfg.DecreaseLatency > 0 && decreaseLatency > bp.cfg.DecreaseLatency.Nanoseconds()
+	moderateLatency := bp.cfg.SameLatency > 0 && sameLatency > bp.cfg.SameLatency.Nanoseconds()
 
-	congestedPercent := float64(congested) / float64(successful+congested)
+	congestedPercent := float64(congested+peerCongested) / float64(successful+congested+peerSuccessful+peerCongested)
 	highCongestion := congestedPercent != 0 && congestedPercent >= bp.cfg.ThresholdPercent
 	moderateCongestion := congestedPercent > 0 && congestedPercent < bp.cfg.ThresholdPercent
 
 	var incr, decr, same int64
+	var rule DecisionRule
+	var decreasePercent float64
 	switch {
 	case highCongestion || highLatency:
-		bp.decr(max)
+		decreasePercent = bp.cfg.DecreasePercent
+		if bp.cfg.DecreaseProportional {
+			decreasePercent = bp.proportionalDecrease(congestedPercent, highCongestion, decreaseLatency, highLatency)
+		}
+
+		bp.lastDecreaseMax, bp.lastDecreaseTo = bp.decr(max, decreasePercent)
+		bp.lastDecreaseAt = now
+		if bp.rl != nil && bp.cfg.RateAdaptive {
+			bp.rl.setRate(bp.rl.rate() * (1 - decreasePercent))
+		}
 		decr++
+		rule = DecisionDecreaseCongestion
+		if !highCongestion {
+			rule = DecisionDecreaseLatency
+		}
 	case moderateCongestion || moderateLatency:
 		same++
 		// keep current max
+		rule = DecisionSameCongestion
+		if !moderateCongestion {
+			rule = DecisionSameLatency
+		}
 	default:
-		bp.incr(max)
+		bp.incr(max, now)
+		if bp.rl != nil && bp.cfg.RateAdaptive {
+			bp.rl.setRate(bp.rl.rate() * (1 + bp.cfg.IncreasePercent))
+		}
 		incr++
+		rule = DecisionIncrease
+	}
+
+	var clusterCapped bool
+	if bp.cfg.Cluster != nil && bp.cfg.Cluster.cfg.GlobalMax > 0 {
+		clusterCapped = bp.capByShare(successful+congested, peerSuccessful+peerCongested)
+	}
+
+	pinned := atomic.LoadInt64(&bp.pinned)
+	if pinned > 0 {
+		atomic.StoreInt64(&bp.max, pinned)
+	}
+
+	var rate float64
+	if bp.rl != nil {
+		rate = bp.rl.rate()
+	}
+
+	if bp.decisions != nil {
+		bp.decisions.add(Decision{
+			Time:             now,
+			OldMax:           max,
+			NewMax:           atomic.LoadInt64(&bp.max),
+			Successful:       successful,
+			Congested:        congested,
+			Denied:           denied,
+			CongestedPercent: congestedPercent,
+			SameLatency:      time.Duration(sameLatency),
+			DecreaseLatency:  time.Duration(decreaseLatency),
+			Rule:             rule,
+			ClusterCapped:    clusterCapped,
+			Pinned:           pinned > 0,
+			Rate:             rate,
+			DecreasePercent:  decreasePercent,
+		})
 	}
 
 	bp.muxStats.Lock()
@@ -245,29 +990,79 @@ func (bp *Backpreassure) decide() {
 		DecideIncreaseCounter: bp.stats.DecideIncreaseCounter + incr,
 		DecideDecreaseCounter: bp.stats.DecideDecreaseCounter + decr,
 		DecideSameCounter:     bp.stats.DecideSameCounter + same,
+		Throughput:            throughput,
+		CongestedPercent:      localCongestedPercent,
+		LastDecideAt:          now,
 	}
 	bp.muxStats.Unlock()
 }
 
-func (bp *Backpreassure) incr(max int64) {
-	newMax := int64(math.Floor(float64(max)*(1+bp.cfg.IncreasePercent)) + 1)
-	if newMax < 0 {
-		newMax = math.MaxInt64
+// earlyDropProbability returns the probability of denying a request given the number of requests in flight.
+func (bp *Backpreassure) earlyDropProbability(inFlight, maxCap int64) float64 {
+	threshold := float64(maxCap) * bp.cfg.EarlyDropPercent
+	if float64(inFlight) <= threshold {
+		return 0
 	}
-	if newMax > bp.cfg.MaxMax {
-		newMax = bp.cfg.MaxMax
+
+	return math.Min(1, (float64(inFlight)-threshold)/(float64(maxCap)-threshold))
+}
+
+// sampleLatency decides whether the latency of a request is recorded and returns the number of requests
+// the recorded latency stands for, zero if it is not recorded. The decision does not depend on the request itself
+// and every sample is weighted by the inverse of the probability it was taken with, so percentiles stay unbiased
+// while the probability changes from period to period.
+func (bp *Backpreassure) sampleLatency() int64 {
+	th := atomic.LoadUint64(&bp.sampleThreshold)
+	if th == math.MaxUint64 {
+		return 1
+	}
+	if rand.Uint64() >= th {
+		return 0
 	}
 
-	atomic.StoreInt64(&bp.max, newMax)
+	// the fraction is rounded at random, so the weight is 1/p on average
+	w := float64(math.MaxUint64) / float64(th)
+	n := int64(w)
+	if rand.Float64() < w-float64(n) {
+		n++
+	}
+
+	return n
+}
+
+func sampleThreshold(p float64) uint64 {
+	if p >= 1 {
+		return math.MaxUint64
+	}
+
+	return uint64(p * math.MaxUint64)
+}
+
+// capByShare limits max to the part of the cluster GlobalMax proportional to the local share of the cluster traffic.
+// It reports whether max was lowered.
+func (bp *Backpreassure) capByShare(local, peers int64) bool {
+	share := float64(local) / float64(local+peers)
+	shareMax := int64(math.Ceil(float64(bp.cfg.Cluster.cfg.GlobalMax) * share))
+	if shareMax < bp.cfg.MinMax {
+		shareMax = bp.cfg.MinMax
+	}
+
+	if atomic.LoadInt64(&bp.max) > shareMax {
+		atomic.StoreInt64(&bp.max, shareMax)
+		return true
+	}
+
+	return false
 }
 
-func (bp *Backpreassure) decr(max int64) {
+// decr decreases max by percent of it, or of the highest usage if that is lower. It returns the value decreased from and the new max.
+func (bp *Backpreassure) decr(max int64, percent float64) (int64, int64) {
 	usedMax := atomic.LoadInt64(&bp.usedMax)
 	if usedMax != 0 && max > usedMax {
 		max = usedMax
 	}
 
-	newMax := int64(math.Ceil(float64(max) * (1 - bp.cfg.DecreasePercent)))
+	newMax := int64(math.Ceil(float64(max) * (1 - percent)))
 	if newMax == max {
 		newMax--
 	}
@@ -275,6 +1070,25 @@ func (bp *Backpreassure) decr(max int64) {
 		newMax = bp.cfg.MinMax
 	}
 	atomic.StoreInt64(&bp.max, newMax)
+
+	return max, newMax
+}
+
+// proportionalDecrease returns the decrease percent scaled by the more severe of congestion and latency.
+func (bp *Backpreassure) proportionalDecrease(congestedPercent float64, highCongestion bool, decreaseLatency int64, highLatency bool) float64 {
+	var severity float64
+	if highCongestion {
+		severity = 1
+		if bp.cfg.ThresholdPercent < 1 {
+			severity = (congestedPercent - bp.cfg.ThresholdPercent) / (1 - bp.cfg.ThresholdPercent)
+		}
+	}
+	if highLatency {
+		threshold := float64(bp.cfg.DecreaseLatency.Nanoseconds())
+		severity = math.Max(severity, (float64(decreaseLatency)-threshold)/threshold)
+	}
+
+	return math.Min(math.Max(bp.cfg.DecreasePercent*severity, bp.cfg.DecreaseMinPercent), bp.cfg.DecreasePercent)
 }
 
 type Token struct {
@@ -282,11 +1096,30 @@ type Token struct {
 	Max int64
 	// Used is the used capacity at the time of token acquisition
 	Used int64
-	// StartAt is a time when the token was acquired in UnixNano format
+	// StartAt is a time when the token was acquired in UnixNano format.
 	StartAt int64
+	// samples is the number of requests the latency of the request stands for when recorded on Release.
+	// It is zero if the latency is not recorded.
+	samples int64
 
 	Congested bool
-	Denied    bool
+	// Denied is set on tokens Acquire did not allow, DenyReason tells why.
+	Denied     bool
+	DenyReason DenyReason
+
+	// Priority is the priority the token was acquired with.
+	Priority Priority
+
+	// Probe is set on tokens let through by a half-open circuit breaker. Their outcome decides whether the breaker closes.
+	Probe bool
+
+	// Tenant is the tenant key the token was acquired with by AcquireTenant.
+	Tenant string
+	tenant *tenant
+
+	// Partition is the partition the token was acquired from by AcquirePartition.
+	Partition string
+	partition *partition
 }
 
 func validateAIMDConfig(cfg Config) error {
@@ -307,15 +1140,15 @@ func validateAIMDConfig(cfg Config) error {
 		return fmt.Errorf("DecreasePercent: required")
 	}
 
-	if err := validatePercent(cfg.IncreasePercent); err != nil {
-		return fmt.Errorf("IncreasePercent: %s", err)
-	}
-	if cfg.IncreasePercent == 0 {
-		return fmt.Errorf("IncreasePercent: required")
+	if err := validateIncrease(cfg); err != nil {
+		return err
 	}
 
-	if cfg.DecreasePercent <= cfg.IncreasePercent {
-		return fmt.Errorf("IncreasePercent: must be less than DecreasePercent")
+	if err := validatePercent(cfg.DecreaseMinPercent); err != nil {
+		return fmt.Errorf("DecreaseMinPercent: %s", err)
+	}
+	if cfg.DecreaseMinPercent > cfg.DecreasePercent {
+		return fmt.Errorf("DecreaseMinPercent: must not be more than DecreasePercent")
 	}
 
 	if err := validatePercent(cfg.ThresholdPercent); err != nil {
@@ -339,6 +1172,144 @@ func validateAIMDConfig(cfg Config) error {
 		return fmt.Errorf("SameLatency: required")
 	}
 
+	if cfg.Rate < 0 {
+		return fmt.Errorf("Rate: negative")
+	}
+	if cfg.RateBurst < 0 {
+		return fmt.Errorf("RateBurst: negative")
+	}
+	if cfg.RateMin < 0 {
+		return fmt.Errorf("RateMin: negative")
+	}
+	if cfg.Rate == 0 && (cfg.RateBurst != 0 || cfg.RateAdaptive || cfg.RateMin != 0) {
+		return fmt.Errorf("Rate: required")
+	}
+	if cfg.RateMin > cfg.Rate {
+		return fmt.Errorf("RateMin: must be less than Rate")
+	}
+
+	if cfg.QueueSize < 0 {
+		return fmt.Errorf("QueueSize: negative")
+	}
+	if cfg.QueueTarget < 0 {
+		return fmt.Errorf("QueueTarget: negative")
+	}
+	if cfg.QueueInterval < 0 {
+		return fmt.Errorf("QueueInterval: negative")
+	}
+	if cfg.QueueSize == 0 && (cfg.QueueTarget != 0 || cfg.QueueInterval != 0) {
+		return fmt.Errorf("QueueSize: required")
+	}
+	if cfg.QueueInterval != 0 && cfg.QueueTarget >= cfg.QueueInterval {
+		return fmt.Errorf("QueueTarget: must be less than QueueInterval")
+	}
+
+	if cfg.BreakerPeriods < 0 {
+		return fmt.Errorf("BreakerPeriods: negative")
+	}
+	if cfg.BreakerCooldown < 0 {
+		return fmt.Errorf("BreakerCooldown: negative")
+	}
+	if cfg.BreakerProbes < 0 {
+		return fmt.Errorf("BreakerProbes: negative")
+	}
+	if cfg.BreakerPeriods == 0 && (cfg.BreakerCooldown != 0 || cfg.BreakerProbes != 0) {
+		return fmt.Errorf("BreakerPeriods: required")
+	}
+
+	if cfg.ThrottleK < 0 {
+		return fmt.Errorf("ThrottleK: negative")
+	}
+	if cfg.ThrottleK > 0 && cfg.ThrottleK < 1 {
+		return fmt.Errorf("ThrottleK: must be at least 1")
+	}
+	if cfg.ThrottleWindow < 0 {
+		return fmt.Errorf("ThrottleWindow: negative")
+	}
+	if cfg.ThrottleK == 0 && cfg.ThrottleWindow != 0 {
+		return fmt.Errorf("ThrottleK: required")
+	}
+	if cfg.ThrottleK > 0 && cfg.QueueSize > 0 {
+		return fmt.Errorf("QueueSize: cannot be used together with ThrottleK")
+	}
+
+	if err := validatePercent(cfg.EarlyDropPercent); err != nil {
+		return fmt.Errorf("EarlyDropPercent: %s", err)
+	}
+	if cfg.EarlyDropPercent == 1 {
+		return fmt.Errorf("EarlyDropPercent: must be less than one")
+	}
+	if cfg.EarlyDropPercent > 0 && cfg.ThrottleK > 0 {
+		return fmt.Errorf("EarlyDropPercent: cannot be used together with ThrottleK")
+	}
+
+	if err := validatePercent(cfg.DefaultPriorityPercent); err != nil {
+		return fmt.Errorf("DefaultPriorityPercent: %s", err)
+	}
+	if err := validatePercent(cfg.SheddablePriorityPercent); err != nil {
+		return fmt.Errorf("SheddablePriorityPercent: %s", err)
+	}
+	if cfg.DefaultPriorityPercent > 0 && cfg.SheddablePriorityPercent > cfg.DefaultPriorityPercent {
+		return fmt.Errorf("SheddablePriorityPercent: must not be more than DefaultPriorityPercent")
+	}
+	// throttling does not enforce max, so there is nothing to reserve for higher priorities
+	if cfg.ThrottleK > 0 && cfg.DefaultPriorityPercent > 0 && cfg.DefaultPriorityPercent < 1 {
+		return fmt.Errorf("DefaultPriorityPercent: cannot be used together with ThrottleK")
+	}
+	if cfg.ThrottleK > 0 && cfg.SheddablePriorityPercent > 0 && cfg.SheddablePriorityPercent < 1 {
+		return fmt.Errorf("SheddablePriorityPercent: cannot be used together with ThrottleK")
+	}
+
+	if err := validatePercent(cfg.TenantReservePercent); err != nil {
+		return fmt.Errorf("TenantReservePercent: %s", err)
+	}
+	if cfg.TenantStatsTop < 0 {
+		return fmt.Errorf("TenantStatsTop: negative")
+	}
+
+	var partitionsPercent float64
+	for name, percent := range cfg.Partitions {
+		if err := validatePercent(percent); err != nil {
+			return fmt.Errorf("Partitions: %s: %s", name, err)
+		}
+		partitionsPercent += percent
+	}
+	if partitionsPercent > 1 {
+		return fmt.Errorf("Partitions: sum of fractions is more than one")
+	}
+	if len(cfg.Partitions) > 0 && cfg.ThrottleK > 0 {
+		return fmt.Errorf("Partitions: cannot be used together with ThrottleK")
+	}
+
+	if cfg.WarmUp < 0 {
+		return fmt.Errorf("WarmUp: negative")
+	}
+	if cfg.WarmUp > 0 && cfg.ThrottleK > 0 {
+		return fmt.Errorf("WarmUp: cannot be used together with ThrottleK")
+	}
+	switch cfg.WarmUpCurve {
+	case "", WarmUpLinear, WarmUpExponential:
+	default:
+		return fmt.Errorf("WarmUpCurve: unknown %q", cfg.WarmUpCurve)
+	}
+
+	if cfg.DecisionHistorySize < -1 {
+		return fmt.Errorf("DecisionHistorySize: must be -1 or more")
+	}
+
+	if cfg.LatencyShards < 0 {
+		return fmt.Errorf("LatencyShards: negative")
+	}
+	if cfg.LatencySampleEvery < 0 {
+		return fmt.Errorf("LatencySampleEvery: negative")
+	}
+	if cfg.LatencySamplesPerPeriod < 0 {
+		return fmt.Errorf("LatencySamplesPerPeriod: negative")
+	}
+	if cfg.LatencySampleEvery > 1 && cfg.LatencySamplesPerPeriod > 0 {
+		return fmt.Errorf("LatencySamplesPerPeriod: cannot be used together with LatencySampleEvery")
+	}
+
 	return nil
 }
 
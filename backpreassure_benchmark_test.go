@@ -51,3 +51,68 @@ func BenchmarkAIMD_Congested(b *testing.B) {
 		bp.Release(t)
 	}
 }
+
+func BenchmarkAIMD_OKParallel(b *testing.B) {
+	bp, err := backpressure.New(backpressure.Config{
+		DecidePeriod:     time.Microsecond * 100,
+		ThresholdPercent: 0.01,
+		IncreasePercent:  0.01,
+		DecreasePercent:  0.8,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			t, _ := bp.Acquire()
+			bp.Release(t)
+		}
+	})
+}
+
+func BenchmarkAIMD_Latency(b *testing.B) {
+	bp, err := backpressure.New(backpressure.Config{
+		DecidePeriod:              time.Millisecond * 100,
+		ThresholdPercent:          0.01,
+		IncreasePercent:           0.01,
+		DecreasePercent:           0.8,
+		DecreaseLatencyPercentile: 0.99,
+		DecreaseLatency:           time.Second,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		t, allowed := bp.Acquire()
+		if !allowed {
+			log.Fatalln("not allowed")
+		}
+		bp.Release(t)
+	}
+}
+
+func BenchmarkAIMD_LatencyParallel(b *testing.B) {
+	bp, err := backpressure.New(backpressure.Config{
+		DecidePeriod:              time.Millisecond * 100,
+		ThresholdPercent:          0.01,
+		IncreasePercent:           0.01,
+		DecreasePercent:           0.8,
+		DecreaseLatencyPercentile: 0.99,
+		DecreaseLatency:           time.Second,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			t, _ := bp.Acquire()
+			bp.Release(t)
+		}
+	})
+}
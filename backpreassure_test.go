@@ -186,6 +186,560 @@ func TestNew(main *testing.T) {
 		require.Nil(t, bp)
 	})
 
+	main.Run("LatencyShardsNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			LatencyShards:    -1,
+		})
+		require.EqualError(t, err, `LatencyShards: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("LatencySampleEveryNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:       time.Second,
+			DecreasePercent:    0.04,
+			IncreasePercent:    0.02,
+			ThresholdPercent:   0.01,
+			LatencySampleEvery: -1,
+		})
+		require.EqualError(t, err, `LatencySampleEvery: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("LatencySamplesPerPeriodNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:            time.Second,
+			DecreasePercent:         0.04,
+			IncreasePercent:         0.02,
+			ThresholdPercent:        0.01,
+			LatencySamplesPerPeriod: -1,
+		})
+		require.EqualError(t, err, `LatencySamplesPerPeriod: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("LatencySamplingBothModes", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:            time.Second,
+			DecreasePercent:         0.04,
+			IncreasePercent:         0.02,
+			ThresholdPercent:        0.01,
+			LatencySampleEvery:      10,
+			LatencySamplesPerPeriod: 100,
+		})
+		require.EqualError(t, err, `LatencySamplesPerPeriod: cannot be used together with LatencySampleEvery`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("RateNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			Rate:             -1,
+		})
+		require.EqualError(t, err, `Rate: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("RateBurstNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			Rate:             10,
+			RateBurst:        -1,
+		})
+		require.EqualError(t, err, `RateBurst: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("RateMinNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			Rate:             10,
+			RateMin:          -1,
+		})
+		require.EqualError(t, err, `RateMin: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("RateRequired", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			RateAdaptive:     true,
+		})
+		require.EqualError(t, err, `Rate: required`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("RateMinAboveRate", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			Rate:             10,
+			RateMin:          20,
+		})
+		require.EqualError(t, err, `RateMin: must be less than Rate`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("QueueSizeNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			QueueSize:        -1,
+		})
+		require.EqualError(t, err, `QueueSize: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("QueueTargetNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			QueueSize:        10,
+			QueueTarget:      -1,
+		})
+		require.EqualError(t, err, `QueueTarget: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("QueueIntervalNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			QueueSize:        10,
+			QueueInterval:    -1,
+		})
+		require.EqualError(t, err, `QueueInterval: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("QueueSizeRequired", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			QueueTarget:      time.Millisecond,
+		})
+		require.EqualError(t, err, `QueueSize: required`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("QueueTargetAboveInterval", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			QueueSize:        10,
+			QueueTarget:      time.Second,
+			QueueInterval:    time.Millisecond,
+		})
+		require.EqualError(t, err, `QueueTarget: must be less than QueueInterval`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("BreakerPeriodsNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			BreakerPeriods:   -1,
+		})
+		require.EqualError(t, err, `BreakerPeriods: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("BreakerCooldownNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			BreakerPeriods:   3,
+			BreakerCooldown:  -1,
+		})
+		require.EqualError(t, err, `BreakerCooldown: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("BreakerProbesNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			BreakerPeriods:   3,
+			BreakerProbes:    -1,
+		})
+		require.EqualError(t, err, `BreakerProbes: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("BreakerPeriodsRequired", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			BreakerProbes:    3,
+		})
+		require.EqualError(t, err, `BreakerPeriods: required`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("ThrottleKNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			ThrottleK:        -1,
+		})
+		require.EqualError(t, err, `ThrottleK: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("ThrottleKTooSmall", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			ThrottleK:        0.5,
+		})
+		require.EqualError(t, err, `ThrottleK: must be at least 1`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("ThrottleWindowNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			ThrottleK:        2,
+			ThrottleWindow:   -1,
+		})
+		require.EqualError(t, err, `ThrottleWindow: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("ThrottleKRequired", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			ThrottleWindow:   time.Minute,
+		})
+		require.EqualError(t, err, `ThrottleK: required`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("ThrottleWithQueue", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			ThrottleK:        2,
+			QueueSize:        10,
+		})
+		require.EqualError(t, err, `QueueSize: cannot be used together with ThrottleK`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("EarlyDropPercentInvalid", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			EarlyDropPercent: 1.2,
+		})
+		require.EqualError(t, err, `EarlyDropPercent: more than one`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("EarlyDropPercentOne", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			EarlyDropPercent: 1,
+		})
+		require.EqualError(t, err, `EarlyDropPercent: must be less than one`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("EarlyDropWithThrottle", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			EarlyDropPercent: 0.8,
+			ThrottleK:        2,
+		})
+		require.EqualError(t, err, `EarlyDropPercent: cannot be used together with ThrottleK`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("DefaultPriorityPercentInvalid", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:           time.Second,
+			DecreasePercent:        0.04,
+			IncreasePercent:        0.02,
+			ThresholdPercent:       0.01,
+			DefaultPriorityPercent: 1.5,
+		})
+		require.EqualError(t, err, `DefaultPriorityPercent: more than one`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("SheddablePriorityPercentInvalid", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:             time.Second,
+			DecreasePercent:          0.04,
+			IncreasePercent:          0.02,
+			ThresholdPercent:         0.01,
+			SheddablePriorityPercent: -0.5,
+		})
+		require.EqualError(t, err, `SheddablePriorityPercent: less than zero`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("SheddablePriorityPercentAboveDefault", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:             time.Second,
+			DecreasePercent:          0.04,
+			IncreasePercent:          0.02,
+			ThresholdPercent:         0.01,
+			DefaultPriorityPercent:   0.5,
+			SheddablePriorityPercent: 0.6,
+		})
+		require.EqualError(t, err, `SheddablePriorityPercent: must not be more than DefaultPriorityPercent`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("TenantReservePercentInvalid", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:         time.Second,
+			DecreasePercent:      0.04,
+			IncreasePercent:      0.02,
+			ThresholdPercent:     0.01,
+			TenantReservePercent: 1.1,
+		})
+		require.EqualError(t, err, `TenantReservePercent: more than one`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("TenantStatsTopNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			TenantStatsTop:   -1,
+		})
+		require.EqualError(t, err, `TenantStatsTop: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("PartitionsInvalidPercent", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			Partitions:       map[string]float64{"search": -0.1},
+		})
+		require.EqualError(t, err, `Partitions: search: less than zero`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("PartitionsSumMoreThanOne", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			Partitions:       map[string]float64{"checkout": 0.6, "search": 0.5},
+		})
+		require.EqualError(t, err, `Partitions: sum of fractions is more than one`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("WarmUpNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			WarmUp:           -time.Second,
+		})
+		require.EqualError(t, err, `WarmUp: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("WarmUpCurveUnknown", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			WarmUp:           time.Second,
+			WarmUpCurve:      "cubic",
+		})
+		require.EqualError(t, err, `WarmUpCurve: unknown "cubic"`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("DecreaseMinPercentInvalid", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:       time.Second,
+			DecreasePercent:    0.04,
+			IncreasePercent:    0.02,
+			ThresholdPercent:   0.01,
+			DecreaseMinPercent: -0.01,
+		})
+		require.EqualError(t, err, `DecreaseMinPercent: less than zero`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("DecreaseMinPercentMoreThanDecreasePercent", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:       time.Second,
+			DecreasePercent:    0.04,
+			IncreasePercent:    0.02,
+			ThresholdPercent:   0.01,
+			DecreaseMinPercent: 0.05,
+		})
+		require.EqualError(t, err, `DecreaseMinPercent: must not be more than DecreasePercent`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("IncreaseFuncUnknown", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			IncreasePercent:  0.02,
+			ThresholdPercent: 0.01,
+			IncreaseFunc:     "exponential",
+		})
+		require.EqualError(t, err, `IncreaseFunc: unknown "exponential"`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("IncreasePercentNotRequired", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			ThresholdPercent: 0.01,
+			IncreaseFunc:     IncreaseSqrt,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, bp)
+	})
+
+	main.Run("IncreasePercentRequiredByRateAdaptive", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			ThresholdPercent: 0.01,
+			IncreaseFunc:     IncreaseSqrt,
+			Rate:             100,
+			RateAdaptive:     true,
+		})
+		require.EqualError(t, err, `IncreasePercent: required by RateAdaptive`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("IncreaseStepNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			ThresholdPercent: 0.01,
+			IncreaseFunc:     IncreaseAdditive,
+			IncreaseStep:     -1,
+		})
+		require.EqualError(t, err, `IncreaseStep: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("IncreaseStepRequired", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			ThresholdPercent: 0.01,
+			IncreaseFunc:     IncreaseAdditive,
+		})
+		require.EqualError(t, err, `IncreaseStep: required`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("IncreaseSqrtFactorNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:       time.Second,
+			DecreasePercent:    0.04,
+			ThresholdPercent:   0.01,
+			IncreaseFunc:       IncreaseSqrt,
+			IncreaseSqrtFactor: -1,
+		})
+		require.EqualError(t, err, `IncreaseSqrtFactor: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("IncreaseCubicCNegative", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.04,
+			ThresholdPercent: 0.01,
+			IncreaseFunc:     IncreaseCubic,
+			IncreaseCubicC:   -0.4,
+		})
+		require.EqualError(t, err, `IncreaseCubicC: negative`)
+		require.Nil(t, bp)
+	})
+
+	main.Run("DecisionHistorySizeInvalid", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:        time.Second,
+			DecreasePercent:     0.04,
+			IncreasePercent:     0.02,
+			ThresholdPercent:    0.01,
+			DecisionHistorySize: -2,
+		})
+		require.EqualError(t, err, `DecisionHistorySize: must be -1 or more`)
+		require.Nil(t, bp)
+	})
+
 	main.Run("MinMaxDefault", func(t *testing.T) {
 		bp, err := New(Config{
 			DecidePeriod:     time.Second,
@@ -210,6 +764,8 @@ func TestNew(main *testing.T) {
 		})
 		require.NoError(t, err)
 		require.Equal(t, int64(math.MaxInt64), bp.cfg.MaxMax)
+		// the midpoint of MinMax and MaxMax must not overflow
+		require.Equal(t, int64(math.MaxInt64/2+1), bp.cfg.Max)
 	})
 }
 
@@ -386,11 +942,12 @@ func TestDecide(main *testing.T) {
 
 		bp.max = 80
 
-		h := bp.h.Merge()
+		bp.lat = newLatencyShards(bp.cfg.NewLatencySketch, bp.cfg.LatencyShards)
+		s := bp.lat.shards[0].windows[0]
 
-		require.NoError(t, h.RecordValue((time.Millisecond * 900).Nanoseconds()))
-		require.NoError(t, h.RecordValue((time.Millisecond * 900).Nanoseconds()))
-		require.NoError(t, h.RecordValue((time.Millisecond * 1100).Nanoseconds()))
+		require.NoError(t, s.Record((time.Millisecond*900).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*900).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*1100).Nanoseconds(), 1))
 
 		bp.successful = 100
 		bp.decide()
@@ -407,11 +964,12 @@ func TestDecide(main *testing.T) {
 
 		bp.max = 80
 
-		h := bp.h.Merge()
+		bp.lat = newLatencyShards(bp.cfg.NewLatencySketch, bp.cfg.LatencyShards)
+		s := bp.lat.shards[0].windows[0]
 
-		require.NoError(t, h.RecordValue((time.Millisecond * 900).Nanoseconds()))
-		require.NoError(t, h.RecordValue((time.Millisecond * 1100).Nanoseconds()))
-		require.NoError(t, h.RecordValue((time.Millisecond * 1100).Nanoseconds()))
+		require.NoError(t, s.Record((time.Millisecond*900).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*1100).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*1100).Nanoseconds(), 1))
 
 		bp.successful = 100
 		bp.decide()
@@ -428,11 +986,37 @@ func TestDecide(main *testing.T) {
 
 		bp.max = 80
 
-		h := bp.h.Merge()
+		bp.lat = newLatencyShards(bp.cfg.NewLatencySketch, bp.cfg.LatencyShards)
+		s := bp.lat.shards[0].windows[0]
+
+		require.NoError(t, s.Record((time.Millisecond*1900).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*2100).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*2100).Nanoseconds(), 1))
+
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(64), bp.max)
+	})
+
+	main.Run("HighLatencyDDSketch", func(t *testing.T) {
+		bp := setUp(t)
+
+		bp.cfg.SameLatencyPercentile = 0.5
+		bp.cfg.SameLatency = time.Second
+		bp.cfg.DecreaseLatencyPercentile = 0.5
+		bp.cfg.DecreaseLatency = time.Second * 2
+		bp.cfg.NewLatencySketch = func() LatencySketch {
+			return NewDDSketch(0.01)
+		}
+
+		bp.max = 80
+
+		bp.lat = newLatencyShards(bp.cfg.NewLatencySketch, bp.cfg.LatencyShards)
+		s := bp.lat.shards[0].windows[0]
 
-		require.NoError(t, h.RecordValue((time.Millisecond * 1900).Nanoseconds()))
-		require.NoError(t, h.RecordValue((time.Millisecond * 2100).Nanoseconds()))
-		require.NoError(t, h.RecordValue((time.Millisecond * 2100).Nanoseconds()))
+		require.NoError(t, s.Record((time.Millisecond*1900).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*2100).Nanoseconds(), 1))
+		require.NoError(t, s.Record((time.Millisecond*2100).Nanoseconds(), 1))
 
 		bp.successful = 100
 		bp.decide()
@@ -460,4 +1044,484 @@ func TestDecide(main *testing.T) {
 		require.Equal(t, int64(72), bp.max)
 	})
 
+	main.Run("DecreaseProportionalCongestion", func(t *testing.T) {
+		bp := setUp(t)
+		bp.cfg.DecreaseProportional = true
+		bp.cfg.DecreaseMinPercent = 0.02
+
+		// at the threshold
+		bp.max = 100
+		bp.successful = 900
+		bp.congested = 100
+		bp.decide()
+		require.Equal(t, int64(98), bp.max)
+
+		// halfway between the threshold and full congestion
+		bp.max = 100
+		bp.successful = 450
+		bp.congested = 550
+		bp.decide()
+		require.Equal(t, int64(90), bp.max)
+
+		bp.max = 100
+		bp.successful = 0
+		bp.congested = 1000
+		bp.decide()
+		require.Equal(t, int64(80), bp.max)
+
+		ds := bp.DecisionHistory()
+		require.InDelta(t, 0.02, ds[0].DecreasePercent, 0.0001)
+		require.InDelta(t, 0.1, ds[1].DecreasePercent, 0.0001)
+		require.InDelta(t, 0.2, ds[2].DecreasePercent, 0.0001)
+	})
+
+	main.Run("DecreaseProportionalLatency", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    100,
+
+			DecreaseLatencyPercentile: 0.9,
+			DecreaseLatency:           time.Millisecond * 100,
+
+			DecreaseProportional: true,
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			bp.lat.record((time.Millisecond * 150).Nanoseconds(), 1)
+		}
+		bp.successful = 100
+		bp.decide()
+		require.InDelta(t, 90, bp.max, 1)
+
+		for i := 0; i < 100; i++ {
+			bp.lat.record((time.Millisecond * 500).Nanoseconds(), 1)
+		}
+		bp.max = 100
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(80), bp.max)
+	})
+}
+
+func TestLatencySampling(main *testing.T) {
+	setUp := func(t *testing.T, cfg Config) *Backpreassure {
+		cfg.DecidePeriod = time.Hour
+		cfg.DecreasePercent = 0.2
+		cfg.IncreasePercent = 0.1
+		cfg.DecreaseLatencyPercentile = 0.9
+		cfg.DecreaseLatency = time.Second
+
+		bp, err := New(cfg)
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	sampled := func(bp *Backpreassure, n int) int {
+		cnt := 0
+		for i := 0; i < n; i++ {
+			tk, allowed := bp.Acquire()
+			if allowed && tk.samples > 0 {
+				cnt++
+			}
+			bp.Release(tk)
+		}
+
+		return cnt
+	}
+
+	main.Run("Disabled", func(t *testing.T) {
+		bp := setUp(t, Config{})
+
+		require.Equal(t, 1000, sampled(bp, 1000))
+	})
+
+	main.Run("OneInN", func(t *testing.T) {
+		bp := setUp(t, Config{
+			LatencySampleEvery: 10,
+		})
+
+		intInRange(t, 800, 1200, sampled(bp, 10000))
+
+		// tokens not sampled still carry the acquisition time
+		for i := 0; i < 100; i++ {
+			tk, allowed := bp.Acquire()
+			require.True(t, allowed)
+			require.NotZero(t, tk.StartAt)
+			bp.Release(tk)
+		}
+	})
+
+	main.Run("PerPeriod", func(t *testing.T) {
+		bp := setUp(t, Config{
+			LatencySamplesPerPeriod: 100,
+		})
+
+		require.Equal(t, 10000, sampled(bp, 10000))
+
+		bp.decide()
+		intInRange(t, 50, 150, sampled(bp, 10000))
+
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, 1000, sampled(bp, 1000))
+	})
+
+	main.Run("PerPeriodWeighted", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp := setUp(t, Config{
+			MaxMax:                  100000,
+			Max:                     20000,
+			LatencySamplesPerPeriod: 100,
+			Clock:                   clock,
+		})
+
+		requests := func(n int, latency time.Duration) {
+			tks := make([]Token, n)
+			for i := range tks {
+				var allowed bool
+				tks[i], allowed = bp.Acquire()
+				require.True(t, allowed)
+			}
+			clock.Advance(latency)
+			for _, tk := range tks {
+				bp.Release(tk)
+			}
+		}
+
+		// a busy period sampled with p=0.01 followed by a quiet one sampled with p=1
+		bp.successful = 10000
+		bp.decide()
+		requests(10000, time.Millisecond*10)
+
+		bp.successful = 100
+		bp.decide()
+		requests(100, time.Second)
+
+		// both periods contribute about 100 samples, weights keep the quiet one at 1% of the latencies
+		qs := bp.lat.quantiles(0.9, 0.995)
+		require.Less(t, qs[0], (time.Millisecond * 100).Nanoseconds())
+		require.Greater(t, qs[1], (time.Millisecond * 500).Nanoseconds())
+	})
+}
+
+func TestAcquire(main *testing.T) {
+	setUp := func(t *testing.T) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    1,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	main.Run("OverLimit", func(t *testing.T) {
+		bp := setUp(t)
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+		require.False(t, t1.Denied)
+		require.Equal(t, DenyReasonNone, t1.DenyReason)
+
+		t2, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.True(t, t2.Denied)
+		require.Equal(t, DenyReasonOverLimit, t2.DenyReason)
+
+		bp.Release(t2)
+		require.Equal(t, int64(1), bp.used)
+		require.Equal(t, int64(0), bp.successful)
+
+		bp.Release(t1)
+		require.Equal(t, int64(0), bp.used)
+		require.Equal(t, int64(1), bp.successful)
+
+		s := bp.Stats()
+		require.Equal(t, int64(1), s.DeniedCounter)
+		require.Equal(t, map[DenyReason]int64{DenyReasonOverLimit: 1}, s.DeniedReasons)
+
+		bp.ResetStats()
+		require.Nil(t, bp.Stats().DeniedReasons)
+	})
+
+	main.Run("Cancel", func(t *testing.T) {
+		bp := setUp(t)
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+
+		bp.Cancel(t1)
+		require.Equal(t, int64(0), bp.used)
+		require.Equal(t, int64(0), bp.successful)
+		require.Equal(t, int64(0), bp.congested)
+		require.Equal(t, int64(0), bp.Stats().Priorities[PriorityDefault].Used)
+
+		t2, allowed := bp.Acquire()
+		require.True(t, allowed)
+		t3, allowed := bp.Acquire()
+		require.False(t, allowed)
+
+		bp.Cancel(t3)
+		require.Equal(t, int64(1), bp.used)
+
+		bp.Release(t2)
+		require.Equal(t, int64(1), bp.successful)
+	})
+
+	main.Run("Close", func(t *testing.T) {
+		bp := setUp(t)
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+
+		require.NoError(t, bp.Close())
+		require.NoError(t, bp.Close())
+
+		t2, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.True(t, t2.Denied)
+		require.Equal(t, DenyReasonShutdown, t2.DenyReason)
+
+		bp.Release(t1)
+		require.Equal(t, int64(0), bp.used)
+		require.Equal(t, map[DenyReason]int64{DenyReasonShutdown: 1}, bp.Stats().DeniedReasons)
+	})
+
+	main.Run("CloseStopsLatencyReset", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:              time.Hour,
+			DecreasePercent:           0.20,
+			IncreasePercent:           0.10,
+			DecreaseLatencyPercentile: 0.9,
+			DecreaseLatency:           time.Second,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, bp.Close())
+		select {
+		case <-bp.doneCh:
+		default:
+			t.Fatal("latency reset goroutine is still running")
+		}
+	})
+}
+
+func TestDenyReason(t *testing.T) {
+	for r := DenyReasonNone; r < denyReasonCount; r++ {
+		text, err := r.MarshalText()
+		require.NoError(t, err)
+
+		var r2 DenyReason
+		require.NoError(t, r2.UnmarshalText(text))
+		require.Equal(t, r, r2)
+		require.Equal(t, string(text), r.String())
+	}
+
+	_, err := DenyReason(200).MarshalText()
+	require.EqualError(t, err, `unknown deny reason 200`)
+
+	var r DenyReason
+	require.EqualError(t, r.UnmarshalText([]byte("foo")), `unknown deny reason "foo"`)
+}
+
+func TestStats(main *testing.T) {
+	setUp := func(t *testing.T) (*Backpreassure, *ManualClock) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    50,
+
+			DecreaseLatencyPercentile: 0.99,
+			DecreaseLatency:           time.Second,
+
+			Clock: clock,
+		})
+		require.NoError(t, err)
+
+		return bp, clock
+	}
+
+	main.Run("BeforeDecide", func(t *testing.T) {
+		bp, _ := setUp(t)
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+		t2, allowed := bp.Acquire()
+		require.True(t, allowed)
+		bp.Release(t1)
+		t2.Congested = true
+		bp.Release(t2)
+		bp.denied = 3
+
+		s := bp.Stats()
+		require.Equal(t, int64(50), s.Max)
+		require.Equal(t, int64(0), s.Used)
+		require.Equal(t, int64(2), s.UsedMax)
+		require.Equal(t, int64(100), s.MaxMax)
+		require.Equal(t, int64(1), s.MaxMin)
+		require.Equal(t, int64(1), s.PeriodSuccessful)
+		require.Equal(t, int64(1), s.PeriodCongested)
+		require.Equal(t, int64(3), s.PeriodDenied)
+		require.Equal(t, int64(1), s.SuccessfulCounter)
+		require.Equal(t, int64(1), s.CongestedCounter)
+		require.Equal(t, int64(3), s.DeniedCounter)
+		require.True(t, s.LastDecideAt.IsZero())
+	})
+
+	main.Run("AfterDecide", func(t *testing.T) {
+		bp, clock := setUp(t)
+
+		for i := int64(1); i <= 100; i++ {
+			bp.lat.record(i*time.Millisecond.Nanoseconds(), 1)
+		}
+
+		clock.Advance(time.Second * 2)
+		bp.successful = 150
+		bp.congested = 50
+		bp.decide()
+
+		bp.successful = 10
+		bp.denied = 2
+
+		s := bp.Stats()
+		require.Equal(t, int64(40), s.Max)
+		require.Equal(t, int64(160), s.SuccessfulCounter)
+		require.Equal(t, int64(50), s.CongestedCounter)
+		require.Equal(t, int64(2), s.DeniedCounter)
+		require.Equal(t, int64(10), s.PeriodSuccessful)
+		require.Equal(t, int64(0), s.PeriodCongested)
+		require.Equal(t, int64(2), s.PeriodDenied)
+		require.Equal(t, float64(100), s.Throughput)
+		require.Equal(t, 0.25, s.CongestedPercent)
+		require.Equal(t, time.Unix(1002, 0), s.LastDecideAt)
+
+		require.InEpsilon(t, time.Millisecond*50, s.LatencyP50, 0.07)
+		require.InEpsilon(t, time.Millisecond*90, s.LatencyP90, 0.07)
+		require.InEpsilon(t, time.Millisecond*99, s.LatencyP99, 0.07)
+		require.InEpsilon(t, time.Millisecond*100, s.LatencyMax, 0.07)
+	})
+
+	main.Run("NoTrafficPeriod", func(t *testing.T) {
+		bp, clock := setUp(t)
+
+		clock.Advance(time.Second)
+		bp.successful = 100
+		bp.decide()
+
+		clock.Advance(time.Second)
+		bp.denied = 5
+		bp.decide()
+
+		s := bp.Stats()
+		require.Equal(t, int64(5), s.DeniedCounter)
+		require.Equal(t, float64(0), s.Throughput)
+		require.Equal(t, time.Unix(1002, 0), s.LastDecideAt)
+	})
+}
+
+func TestEarlyDrop(main *testing.T) {
+	setUp := func(t *testing.T) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 1000,
+			Max:    100,
+
+			EarlyDropPercent: 0.5,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	main.Run("Probability", func(t *testing.T) {
+		bp := setUp(t)
+
+		require.Equal(t, float64(0), bp.earlyDropProbability(0, 100))
+		require.Equal(t, float64(0), bp.earlyDropProbability(50, 100))
+		require.Equal(t, 0.5, bp.earlyDropProbability(75, 100))
+		require.Equal(t, float64(1), bp.earlyDropProbability(100, 100))
+		require.Equal(t, float64(1), bp.earlyDropProbability(120, 100))
+	})
+
+	main.Run("BelowThreshold", func(t *testing.T) {
+		bp := setUp(t)
+
+		for i := 0; i < 51; i++ {
+			_, allowed := bp.Acquire()
+			require.True(t, allowed)
+		}
+		require.InDelta(t, 0.02, bp.Stats().EarlyDropProbability, 0.0001)
+	})
+
+	main.Run("SoftZone", func(t *testing.T) {
+		bp := setUp(t)
+		bp.used = 75
+
+		var denied int
+		for i := 0; i < 10000; i++ {
+			tk, allowed := bp.Acquire()
+			if allowed {
+				bp.Release(tk)
+				continue
+			}
+
+			denied++
+			require.Equal(t, DenyReasonEarlyDrop, tk.DenyReason)
+		}
+
+		intInRange(t, 4500, 5500, denied)
+		require.Equal(t, int64(75), bp.used)
+		require.Equal(t, 0.5, bp.Stats().EarlyDropProbability)
+	})
+
+	main.Run("AtMax", func(t *testing.T) {
+		bp := setUp(t)
+
+		// with 99 in flight a request is dropped with probability 0.98
+		var dropped int
+		for i := 0; i < 1000; i++ {
+			bp.used = 99
+			tk, allowed := bp.Acquire()
+			if !allowed {
+				require.Equal(t, DenyReasonEarlyDrop, tk.DenyReason)
+				dropped++
+			}
+		}
+		intInRange(t, 950, 1000, dropped)
+
+		bp.used = 100
+		tk, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonOverLimit, tk.DenyReason)
+	})
+}
+
+func intInRange(t *testing.T, from, to, act int) {
+	require.GreaterOrEqual(t, act, from)
+	require.LessOrEqual(t, act, to)
 }
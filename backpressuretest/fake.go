@@ -0,0 +1,173 @@
+// Package backpressuretest provides helpers for testing code which uses backpressure limiters.
+package backpressuretest
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/makasim/backpressure"
+)
+
+// Fake is a scriptable backpressure.Limiter. It allows or denies requests in the scripted order
+// and falls back to the default decision once the script is over. It is safe for concurrent use.
+type Fake struct {
+	mux      sync.Mutex
+	script   []bool
+	def      bool
+	max      int64
+	used     int64
+	stats    backpressure.AIMDStats
+	released []backpressure.Token
+	canceled []backpressure.Token
+}
+
+var _ backpressure.Limiter = (*Fake)(nil)
+
+// NewFake returns a fake limiter which allows every request unless scripted otherwise.
+func NewFake() *Fake {
+	return &Fake{
+		def: true,
+		max: 1,
+	}
+}
+
+// Script appends decisions for the following Acquire calls.
+func (f *Fake) Script(allowed ...bool) *Fake {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.script = append(f.script, allowed...)
+
+	return f
+}
+
+// AllowNext scripts the next n Acquire calls to be allowed.
+func (f *Fake) AllowNext(n int) *Fake {
+	return f.Script(repeat(true, n)...)
+}
+
+// DenyNext scripts the next n Acquire calls to be denied.
+func (f *Fake) DenyNext(n int) *Fake {
+	return f.Script(repeat(false, n)...)
+}
+
+// SetDefault sets the decision used once the script is over.
+func (f *Fake) SetDefault(allowed bool) *Fake {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.def = allowed
+
+	return f
+}
+
+// SetMax sets Max reported in tokens and stats.
+func (f *Fake) SetMax(max int64) *Fake {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.max = max
+
+	return f
+}
+
+func (f *Fake) Acquire() (backpressure.Token, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	allowed := f.def
+	if len(f.script) > 0 {
+		allowed = f.script[0]
+		f.script = f.script[1:]
+	}
+
+	if !allowed {
+		f.stats.DeniedCounter++
+		if f.stats.DeniedReasons == nil {
+			f.stats.DeniedReasons = make(map[backpressure.DenyReason]int64)
+		}
+		f.stats.DeniedReasons[backpressure.DenyReasonOverLimit]++
+
+		return backpressure.Token{
+			Max:        f.max,
+			Used:       f.used + 1,
+			Denied:     true,
+			DenyReason: backpressure.DenyReasonOverLimit,
+		}, false
+	}
+
+	f.used++
+
+	return backpressure.Token{
+		Max:  f.max,
+		Used: f.used,
+	}, true
+}
+
+func (f *Fake) Release(t backpressure.Token) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.released = append(f.released, t)
+	if t.Denied {
+		return
+	}
+
+	f.used--
+	if t.Congested {
+		f.stats.CongestedCounter++
+	} else {
+		f.stats.SuccessfulCounter++
+	}
+}
+
+// Cancel gives back the capacity taken by the token without counting the request as successful or congested.
+func (f *Fake) Cancel(t backpressure.Token) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.canceled = append(f.canceled, t)
+	if t.Denied {
+		return
+	}
+
+	f.used--
+}
+
+func (f *Fake) Stats() backpressure.AIMDStats {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	s := f.stats
+	s.Max = f.max
+	s.EffectiveMax = f.max
+	s.Used = f.used
+	s.DeniedReasons = maps.Clone(f.stats.DeniedReasons)
+
+	return s
+}
+
+// Released returns tokens passed to Release in the order they were released.
+func (f *Fake) Released() []backpressure.Token {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return append([]backpressure.Token(nil), f.released...)
+}
+
+// Canceled returns tokens passed to Cancel in the order they were canceled.
+func (f *Fake) Canceled() []backpressure.Token {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return append([]backpressure.Token(nil), f.canceled...)
+}
+
+func repeat(v bool, n int) []bool {
+	vs := make([]bool, n)
+	for i := range vs {
+		vs[i] = v
+	}
+
+	return vs
+}
@@ -0,0 +1,103 @@
+package backpressuretest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/makasim/backpressure"
+	"github.com/makasim/backpressure/backpressuretest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(main *testing.T) {
+	main.Run("AllowByDefault", func(t *testing.T) {
+		f := backpressuretest.NewFake()
+
+		tk, allowed := f.Acquire()
+		require.True(t, allowed)
+		require.Equal(t, int64(1), tk.Used)
+
+		tk.Congested = true
+		f.Release(tk)
+
+		s := f.Stats()
+		require.Equal(t, int64(0), s.Used)
+		require.Equal(t, int64(1), s.CongestedCounter)
+		require.Len(t, f.Released(), 1)
+		require.True(t, f.Released()[0].Congested)
+	})
+
+	main.Run("Script", func(t *testing.T) {
+		f := backpressuretest.NewFake().
+			DenyNext(2).
+			AllowNext(1).
+			Script(false).
+			SetDefault(false)
+
+		var decisions []bool
+		for i := 0; i < 6; i++ {
+			tk, allowed := f.Acquire()
+			decisions = append(decisions, allowed)
+			if allowed {
+				f.Release(tk)
+			} else {
+				require.True(t, tk.Denied)
+				require.Equal(t, backpressure.DenyReasonOverLimit, tk.DenyReason)
+				f.Release(tk)
+			}
+		}
+
+		require.Equal(t, []bool{false, false, true, false, false, false}, decisions)
+		require.Equal(t, int64(5), f.Stats().DeniedCounter)
+		require.Equal(t, map[backpressure.DenyReason]int64{backpressure.DenyReasonOverLimit: 5}, f.Stats().DeniedReasons)
+		require.Equal(t, int64(1), f.Stats().SuccessfulCounter)
+		require.Equal(t, int64(0), f.Stats().Used)
+	})
+
+	main.Run("Cancel", func(t *testing.T) {
+		f := backpressuretest.NewFake()
+		c := backpressure.NewComposite(f, backpressuretest.NewFake().DenyNext(1))
+
+		_, allowed := c.Acquire()
+		require.False(t, allowed)
+
+		s := f.Stats()
+		require.Equal(t, int64(0), s.Used)
+		require.Equal(t, int64(0), s.SuccessfulCounter)
+		require.Equal(t, int64(0), s.CongestedCounter)
+		require.Len(t, f.Canceled(), 1)
+		require.Empty(t, f.Released())
+	})
+
+	main.Run("Max", func(t *testing.T) {
+		f := backpressuretest.NewFake().SetMax(10)
+
+		tk, _ := f.Acquire()
+		require.Equal(t, int64(10), tk.Max)
+		require.Equal(t, int64(10), f.Stats().Max)
+		require.Equal(t, int64(10), f.Stats().EffectiveMax)
+	})
+}
+
+func TestScenario(t *testing.T) {
+	f := backpressuretest.NewFake().
+		AllowNext(10).
+		SetDefault(false)
+
+	backpressuretest.Scenario{
+		Limiter:       f,
+		ClientRPMS:    10,
+		ProxyWorkers:  5,
+		OriginWorkers: 5,
+		Origin: func(idx, used int64) error {
+			return nil
+		},
+		Duration: time.Millisecond * 100,
+	}.Run().Require(t, backpressuretest.Expect{
+		OK:         backpressuretest.Between(10, 10),
+		Failed:     backpressuretest.AtLeast(1),
+		Used:       backpressuretest.Between(0, 0),
+		Successful: backpressuretest.Between(10, 10),
+		Denied:     backpressuretest.AtLeast(1),
+	})
+}
@@ -0,0 +1,302 @@
+package backpressuretest
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/makasim/backpressure"
+	"github.com/stretchr/testify/require"
+)
+
+// Scenario runs a client sending requests at a fixed rate through a proxy guarded by a limiter to an origin.
+// The client counts a request as failed when the proxy is busy, the limiter denies it or the origin fails it.
+// When the origin has no free worker to take a request, the proxy marks the token congested
+// and the request is never answered, as if it timed out on the client side.
+type Scenario struct {
+	Limiter backpressure.Limiter
+
+	// ClientRPMS is a number of requests the client sends per TimeScale milliseconds.
+	ClientRPMS int
+	// ProxyWorkers is a number of requests the proxy handles concurrently.
+	ProxyWorkers int
+	// OriginWorkers is a number of requests the origin handles concurrently.
+	OriginWorkers int
+	// Origin handles a request. idx is a sequence number of the request, used is a number of requests the origin handles at the moment.
+	Origin func(idx, used int64) error
+
+	// Duration defines how long the scenario runs.
+	Duration time.Duration
+	// TimeScale slows the client down, so the scenario can run on slower machines. Default 1
+	TimeScale time.Duration
+}
+
+type Result struct {
+	OK     int64
+	Failed int64
+	Stats  backpressure.AIMDStats
+}
+
+// Run runs the scenario for Duration and returns client and limiter stats taken before the scenario stops.
+func (s Scenario) Run() Result {
+	if s.TimeScale == 0 {
+		s.TimeScale = 1
+	}
+
+	c := NewClient(s.ClientRPMS, s.TimeScale)
+	p := NewProxy(c.OutCh, s.ProxyWorkers, s.Limiter)
+	o := NewOrigin(s.Origin, s.OriginWorkers, p.OutCh)
+
+	defer o.Run()()
+	defer p.Run()()
+	defer c.Run()()
+
+	time.Sleep(s.Duration)
+
+	ok, failed := c.Stats()
+
+	return Result{
+		OK:     ok,
+		Failed: failed,
+		Stats:  s.Limiter.Stats(),
+	}
+}
+
+// Range is an inclusive range of expected values.
+type Range struct {
+	From int64
+	To   int64
+}
+
+func Between(from, to int64) *Range {
+	return &Range{From: from, To: to}
+}
+
+// AtLeast returns a range without an upper bound.
+func AtLeast(from int64) *Range {
+	return &Range{From: from, To: math.MaxInt64}
+}
+
+// Expect describes ranges the result must fall into. Nil ranges are not checked.
+type Expect struct {
+	OK     *Range
+	Failed *Range
+
+	Used              *Range
+	Max               *Range
+	Successful        *Range
+	Congested         *Range
+	Denied            *Range
+	DeniedOrCongested *Range
+}
+
+func (r Result) Require(t testing.TB, exp Expect) {
+	t.Helper()
+
+	requireRange(t, "OK", exp.OK, r.OK)
+	requireRange(t, "Failed", exp.Failed, r.Failed)
+	requireRange(t, "Used", exp.Used, r.Stats.Used)
+	requireRange(t, "Max", exp.Max, r.Stats.Max)
+	requireRange(t, "Successful", exp.Successful, r.Stats.SuccessfulCounter)
+	requireRange(t, "Congested", exp.Congested, r.Stats.CongestedCounter)
+	requireRange(t, "Denied", exp.Denied, r.Stats.DeniedCounter)
+	requireRange(t, "DeniedOrCongested", exp.DeniedOrCongested, r.Stats.DeniedCounter+r.Stats.CongestedCounter)
+}
+
+func requireRange(t testing.TB, name string, exp *Range, act int64) {
+	t.Helper()
+
+	if exp == nil {
+		return
+	}
+
+	require.GreaterOrEqual(t, act, exp.From, name)
+	require.LessOrEqual(t, act, exp.To, name)
+}
+
+type Request struct {
+	ResCh chan error
+}
+
+// Client sends ClientRPMS requests per TimeScale milliseconds to OutCh.
+type Client struct {
+	OutCh chan Request
+
+	rpms      int
+	timeScale time.Duration
+
+	ok     int64
+	failed int64
+}
+
+func NewClient(rpms int, timeScale time.Duration) *Client {
+	return &Client{
+		OutCh:     make(chan Request, 20),
+		rpms:      rpms,
+		timeScale: timeScale,
+	}
+}
+
+// Run starts the client and returns a function stopping it.
+func (c *Client) Run() func() {
+	closeCh := make(chan struct{})
+
+	go c.worker(closeCh)
+
+	return func() {
+		close(closeCh)
+	}
+}
+
+func (c *Client) worker(closeCh chan struct{}) {
+	internalRPS := int(math.Ceil(float64(c.rpms / 10)))
+	tokensCh := make(chan struct{}, internalRPS)
+
+	t := time.NewTicker(time.Microsecond * 100 * c.timeScale)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		fill:
+			for {
+				select {
+				case tokensCh <- struct{}{}:
+				default:
+					break fill
+				}
+			}
+		case <-tokensCh:
+			go func() {
+				req := Request{ResCh: make(chan error, 1)}
+
+				select {
+				case c.OutCh <- req:
+					if err := <-req.ResCh; err != nil {
+						atomic.AddInt64(&c.failed, 1)
+					} else {
+						atomic.AddInt64(&c.ok, 1)
+					}
+				default:
+					atomic.AddInt64(&c.failed, 1)
+				}
+			}()
+		case <-closeCh:
+			return
+		}
+	}
+}
+
+// Stats returns numbers of succeeded and failed requests.
+func (c *Client) Stats() (int64, int64) {
+	ok := atomic.LoadInt64(&c.ok)
+	failed := atomic.LoadInt64(&c.failed)
+
+	return ok, failed
+}
+
+// Proxy passes requests from inCh to OutCh if the limiter allows.
+type Proxy struct {
+	OutCh chan Request
+
+	inCh chan Request
+	l    backpressure.Limiter
+	wNum int
+}
+
+func NewProxy(inCh chan Request, wNum int, l backpressure.Limiter) *Proxy {
+	return &Proxy{
+		OutCh: make(chan Request, 20),
+		inCh:  inCh,
+		l:     l,
+		wNum:  wNum,
+	}
+}
+
+// Run starts the proxy workers and returns a function stopping them.
+func (p *Proxy) Run() func() {
+	closeCh := make(chan struct{})
+
+	for i := 0; i < p.wNum; i++ {
+		go p.worker(closeCh)
+	}
+
+	return func() {
+		close(closeCh)
+	}
+}
+
+func (p *Proxy) worker(closeCh chan struct{}) {
+	for {
+		select {
+		case req := <-p.inCh:
+			t, allowed := p.l.Acquire()
+			if !allowed {
+				req.ResCh <- fmt.Errorf("bp: disallowed")
+				continue
+			}
+
+			clientResCh := req.ResCh
+			req.ResCh = make(chan error, 1)
+
+			select {
+			case p.OutCh <- req:
+				res := <-req.ResCh
+				clientResCh <- res
+				p.l.Release(t)
+			default:
+				req.ResCh <- fmt.Errorf("client: no capacity")
+				t.Congested = true
+				p.l.Release(t)
+			}
+		case <-closeCh:
+			return
+		}
+	}
+}
+
+// Origin handles requests from inCh with wNum workers.
+type Origin struct {
+	inCh chan Request
+	wNum int
+	idx  int64
+	used int64
+	h    func(idx, used int64) error
+}
+
+func NewOrigin(h func(idx, used int64) error, wNum int, inCh chan Request) *Origin {
+	return &Origin{
+		inCh: inCh,
+		wNum: wNum,
+		h:    h,
+	}
+}
+
+// Run starts the origin workers and returns a function stopping them.
+func (o *Origin) Run() func() {
+	closeCh := make(chan struct{})
+
+	for i := 0; i < o.wNum; i++ {
+		go o.worker(closeCh)
+	}
+
+	return func() {
+		close(closeCh)
+	}
+}
+
+func (o *Origin) worker(closeCh chan struct{}) {
+	for {
+		select {
+		case req := <-o.inCh:
+			used := atomic.AddInt64(&o.used, 1)
+			idx := atomic.AddInt64(&o.idx, 1)
+			req.ResCh <- o.h(idx, used)
+			atomic.AddInt64(&o.used, -1)
+		case <-closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package backpressure
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+const (
+	breakerClosed int32 = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker stops sending requests to an upstream which is completely down.
+// It opens after a number of consecutive fully congested periods and denies all requests for a cooldown.
+// Then it lets a number of probe requests through and closes once all of them succeed, a congested probe opens it again.
+type breaker struct {
+	state    int32
+	openedAt int64
+	probes   int64
+	probeOK  int64
+
+	// mux guards state transitions, the state itself is read without it
+	mux     sync.Mutex
+	periods int
+
+	cfgPeriods  int
+	cfgCooldown int64
+	cfgProbes   int64
+}
+
+func newBreaker(periods int, cooldown, probes int64) *breaker {
+	return &breaker{
+		cfgPeriods:  periods,
+		cfgCooldown: cooldown,
+		cfgProbes:   probes,
+	}
+}
+
+// allow tells whether a request may pass and whether it is a probe.
+func (b *breaker) allow(clock Clock) (bool, bool) {
+	for {
+		switch atomic.LoadInt32(&b.state) {
+		case breakerClosed:
+			return true, false
+		case breakerOpen:
+			now := clock.Now().UnixNano()
+			if now-atomic.LoadInt64(&b.openedAt) < b.cfgCooldown {
+				return false, false
+			}
+
+			b.mux.Lock()
+			if b.state == breakerOpen && now-b.openedAt >= b.cfgCooldown {
+				atomic.StoreInt64(&b.probes, b.cfgProbes)
+				atomic.StoreInt64(&b.probeOK, 0)
+				atomic.StoreInt32(&b.state, breakerHalfOpen)
+			}
+			b.mux.Unlock()
+		case breakerHalfOpen:
+			if atomic.AddInt64(&b.probes, -1) < 0 {
+				atomic.AddInt64(&b.probes, 1)
+				return false, false
+			}
+
+			return true, true
+		}
+	}
+}
+
+// cancelProbe gives back a probe which was not sent.
+func (b *breaker) cancelProbe() {
+	atomic.AddInt64(&b.probes, 1)
+}
+
+func (b *breaker) release(congested bool, now int64) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.state != breakerHalfOpen {
+		return
+	}
+
+	if congested {
+		atomic.StoreInt64(&b.openedAt, now)
+		atomic.StoreInt32(&b.state, breakerOpen)
+		return
+	}
+
+	if atomic.AddInt64(&b.probeOK, 1) >= b.cfgProbes {
+		b.periods = 0
+		atomic.StoreInt32(&b.state, breakerClosed)
+	}
+}
+
+// period counts consecutive fully congested periods and opens the breaker once there are enough of them.
+func (b *breaker) period(successful, congested, now int64) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if congested > 0 && successful == 0 {
+		b.periods++
+	} else {
+		b.periods = 0
+	}
+
+	if b.state == breakerClosed && b.periods >= b.cfgPeriods {
+		atomic.StoreInt64(&b.openedAt, now)
+		atomic.StoreInt32(&b.state, breakerOpen)
+	}
+}
+
+func (b *breaker) currentState() BreakerState {
+	switch atomic.LoadInt32(&b.state) {
+	case breakerOpen:
+		return BreakerOpen
+	case breakerHalfOpen:
+		return BreakerHalfOpen
+	default:
+		return BreakerClosed
+	}
+}
@@ -0,0 +1,232 @@
+package backpressure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(main *testing.T) {
+	setUp := func(t *testing.T, probes int64) (*Backpreassure, *ManualClock) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    10,
+
+			BreakerPeriods:  3,
+			BreakerCooldown: time.Second * 5,
+			BreakerProbes:   probes,
+
+			Clock: clock,
+		})
+		require.NoError(t, err)
+
+		return bp, clock
+	}
+
+	congestedPeriod := func(bp *Backpreassure, clock *ManualClock) {
+		bp.congested = 10
+		bp.decide()
+		clock.Advance(time.Second)
+	}
+
+	main.Run("Defaults", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:    time.Second,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+			BreakerPeriods:  3,
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, time.Second*10, bp.cfg.BreakerCooldown)
+		require.Equal(t, int64(1), bp.cfg.BreakerProbes)
+		require.Equal(t, BreakerClosed, bp.Stats().BreakerState)
+	})
+
+	main.Run("Disabled", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:    time.Second,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			bp.congested = 10
+			bp.decide()
+		}
+
+		_, allowed := bp.Acquire()
+		require.True(t, allowed)
+		require.Equal(t, BreakerState(""), bp.Stats().BreakerState)
+	})
+
+	main.Run("OpensAfterConsecutivePeriods", func(t *testing.T) {
+		bp, clock := setUp(t, 1)
+
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+
+		// a single successful request resets the count
+		bp.successful = 1
+		bp.congested = 10
+		bp.decide()
+
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+		require.Equal(t, BreakerClosed, bp.Stats().BreakerState)
+
+		congestedPeriod(bp, clock)
+		require.Equal(t, BreakerOpen, bp.Stats().BreakerState)
+
+		tk, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonCircuitOpen, tk.DenyReason)
+		require.Equal(t, map[DenyReason]int64{DenyReasonCircuitOpen: 1}, bp.Stats().DeniedReasons)
+	})
+
+	main.Run("ProbesClose", func(t *testing.T) {
+		bp, clock := setUp(t, 2)
+
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+		require.Equal(t, BreakerOpen, bp.Stats().BreakerState)
+
+		clock.Advance(time.Second * 3)
+		_, allowed := bp.Acquire()
+		require.False(t, allowed)
+
+		clock.Advance(time.Second * 2)
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+		require.True(t, t1.Probe)
+		require.Equal(t, BreakerHalfOpen, bp.Stats().BreakerState)
+
+		t2, allowed := bp.Acquire()
+		require.True(t, allowed)
+		require.True(t, t2.Probe)
+
+		// all probes are in flight
+		t3, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonCircuitOpen, t3.DenyReason)
+
+		bp.Release(t1)
+		require.Equal(t, BreakerHalfOpen, bp.Stats().BreakerState)
+
+		bp.Release(t2)
+		require.Equal(t, BreakerClosed, bp.Stats().BreakerState)
+
+		t4, allowed := bp.Acquire()
+		require.True(t, allowed)
+		require.False(t, t4.Probe)
+		bp.Release(t4)
+
+		// the breaker counts periods from zero again
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+		require.Equal(t, BreakerClosed, bp.Stats().BreakerState)
+	})
+
+	main.Run("CongestedProbeReopens", func(t *testing.T) {
+		bp, clock := setUp(t, 1)
+
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+
+		clock.Advance(time.Second * 5)
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+		require.True(t, t1.Probe)
+
+		t1.Congested = true
+		bp.Release(t1)
+		require.Equal(t, BreakerOpen, bp.Stats().BreakerState)
+
+		clock.Advance(time.Second * 4)
+		_, allowed = bp.Acquire()
+		require.False(t, allowed)
+
+		clock.Advance(time.Second)
+		_, allowed = bp.Acquire()
+		require.True(t, allowed)
+	})
+
+	main.Run("ProbeDeniedByMax", func(t *testing.T) {
+		bp, clock := setUp(t, 1)
+
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+		congestedPeriod(bp, clock)
+
+		// a request acquired before the breaker opened still holds the only slot
+		bp.max = 1
+		bp.used = 1
+
+		clock.Advance(time.Second * 5)
+		tk, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonOverLimit, tk.DenyReason)
+
+		bp.used = 0
+		tk, allowed = bp.Acquire()
+		require.True(t, allowed)
+		require.True(t, tk.Probe)
+	})
+
+	main.Run("OpenDeniesQueuedWaiters", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    1,
+
+			BreakerPeriods: 1,
+			QueueSize:      10,
+		})
+		require.NoError(t, err)
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+
+		resCh := make(chan Token, 1)
+		go func() {
+			tk, _ := bp.AcquireWait(context.Background())
+			resCh <- tk
+		}()
+		require.Eventually(t, func() bool {
+			return bp.q.len() == 1
+		}, time.Second, time.Millisecond)
+
+		bp.congested = 10
+		bp.decide()
+		require.Equal(t, BreakerOpen, bp.Stats().BreakerState)
+
+		tk := <-resCh
+		require.True(t, tk.Denied)
+		require.Equal(t, DenyReasonCircuitOpen, tk.DenyReason)
+
+		tk, allowed = bp.AcquireWait(context.Background())
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonCircuitOpen, tk.DenyReason)
+		require.Equal(t, int64(0), bp.q.len())
+
+		bp.Release(t1)
+		require.Equal(t, int64(0), bp.used)
+	})
+}
@@ -0,0 +1,131 @@
+package backpressure
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides time to a limiter. The default clock uses the time package,
+// ManualClock lets a limiter run in simulated time.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{t: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// ManualClock is a Clock which moves only when told to.
+// Its tickers behave like time.Ticker: a tick is dropped if the previous one has not been received yet.
+type ManualClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{
+		now: now,
+	}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("backpressure: non-positive interval for ManualClock.NewTicker")
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &manualTicker{
+		c:      c,
+		ch:     make(chan time.Time, 1),
+		d:      d,
+		nextAt: c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+
+	return t
+}
+
+// Advance moves the clock forward by d and fires tickers which became due.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if c.now.Before(t.nextAt) {
+			continue
+		}
+
+		select {
+		case t.ch <- t.nextAt:
+		default:
+		}
+		for !c.now.Before(t.nextAt) {
+			t.nextAt = t.nextAt.Add(t.d)
+		}
+	}
+}
+
+// Set moves the clock forward to now. It does nothing if now is before the current time.
+func (c *ManualClock) Set(now time.Time) {
+	if d := now.Sub(c.Now()); d > 0 {
+		c.Advance(d)
+	}
+}
+
+type manualTicker struct {
+	c      *ManualClock
+	ch     chan time.Time
+	d      time.Duration
+	nextAt time.Time
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTicker) Stop() {
+	t.c.mux.Lock()
+	defer t.c.mux.Unlock()
+
+	for i, t2 := range t.c.tickers {
+		if t2 == t {
+			t.c.tickers = append(t.c.tickers[:i], t.c.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManualClock(main *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	main.Run("Advance", func(t *testing.T) {
+		c := NewManualClock(start)
+		c.Advance(time.Second)
+		require.Equal(t, start.Add(time.Second), c.Now())
+
+		c.Set(start)
+		require.Equal(t, start.Add(time.Second), c.Now())
+
+		c.Set(start.Add(time.Minute))
+		require.Equal(t, start.Add(time.Minute), c.Now())
+	})
+
+	main.Run("Ticker", func(t *testing.T) {
+		c := NewManualClock(start)
+		tk := c.NewTicker(time.Second)
+
+		c.Advance(time.Millisecond * 999)
+		require.Len(t, tk.C(), 0)
+
+		c.Advance(time.Millisecond)
+		require.Equal(t, start.Add(time.Second), <-tk.C())
+
+		// ticks are dropped when nobody receives them
+		c.Advance(time.Second * 5)
+		require.Equal(t, start.Add(time.Second*2), <-tk.C())
+		require.Len(t, tk.C(), 0)
+
+		c.Advance(time.Second)
+		require.Equal(t, start.Add(time.Second*7), <-tk.C())
+
+		tk.Stop()
+		c.Advance(time.Second)
+		require.Len(t, tk.C(), 0)
+	})
+
+	main.Run("DecideInSimulatedTime", func(t *testing.T) {
+		c := NewManualClock(start)
+
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+			MaxMax:           100,
+			Max:              80,
+			Clock:            c,
+		})
+		require.NoError(t, err)
+
+		tk, _ := bp.Acquire()
+		require.Equal(t, start.UnixNano(), tk.StartAt)
+		bp.Release(tk)
+
+		tk, _ = bp.Acquire()
+		bp.Release(tk)
+		require.Equal(t, int64(80), bp.max)
+
+		c.Advance(time.Second)
+		tk, _ = bp.Acquire()
+		bp.Release(tk)
+		require.Equal(t, int64(89), bp.max)
+	})
+}
@@ -0,0 +1,330 @@
+package backpressure
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const clusterMsgVersion = 1
+
+type ClusterConfig struct {
+	// Name identifies the replica among its peers and must be unique. Default the host name and the port listened on
+	Name string
+
+	// Addr is a UDP address to listen on for peer reports, for example 127.0.0.1:7946
+	Addr string
+
+	// Peers lists UDP addresses of other replicas. More can be added later with AddPeer.
+	// Reports are accepted only from these addresses.
+	Peers []string
+
+	// PeerTTL defines for how long a peer report is taken into account. Default 3 * DecidePeriod
+	PeerTTL time.Duration
+
+	// GlobalMax defines a capacity shared by the whole cluster.
+	// When set, each replica caps its max by GlobalMax multiplied by its share of the cluster traffic.
+	GlobalMax int64
+}
+
+// Cluster lets replicas of a limiter learn from each other.
+// On every decide a replica sends its per-period successful and congested counts and its current max to all peers
+// and takes the cluster-wide congestion ratio into account instead of only its own.
+// Reports are sent from a background goroutine, so decide never waits on the network.
+// Reports are identified by the replica Name only, so a Cluster serves a single limiter and New rejects sharing it.
+type Cluster struct {
+	cfg  ClusterConfig
+	conn *net.UDPConn
+
+	mux      sync.Mutex
+	attached bool
+	clock    Clock
+	peers    []*net.UDPAddr
+	reports  map[string]clusterReport
+	out      []byte
+
+	sendCh  chan struct{}
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+type clusterReport struct {
+	successful int64
+	congested  int64
+	max        int64
+	at         time.Time
+}
+
+func NewCluster(cfg ClusterConfig) (*Cluster, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("Addr: required")
+	}
+	if cfg.PeerTTL < 0 {
+		return nil, fmt.Errorf("PeerTTL: negative")
+	}
+	if cfg.GlobalMax < 0 {
+		return nil, fmt.Errorf("GlobalMax: negative")
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("Addr: %s", err)
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %s", err)
+	}
+
+	if cfg.Name == "" {
+		// the local address is the same on every replica bound to a wildcard address
+		hostname, err := os.Hostname()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Name: %s", err)
+		}
+		cfg.Name = net.JoinHostPort(hostname, strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port))
+	}
+	if len(cfg.Name) > math.MaxUint8 {
+		conn.Close()
+		return nil, fmt.Errorf("Name: too long")
+	}
+
+	c := &Cluster{
+		cfg:     cfg,
+		conn:    conn,
+		clock:   realClock{},
+		reports: make(map[string]clusterReport),
+		sendCh:  make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+	}
+
+	for _, addr := range cfg.Peers {
+		if err := c.AddPeer(addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	c.wg.Add(2)
+	go c.receive()
+	go c.send()
+
+	return c, nil
+}
+
+// Addr returns the address the cluster listens on.
+func (c *Cluster) Addr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Cluster) AddPeer(addr string) error {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("peer %s: %s", addr, err)
+	}
+
+	c.mux.Lock()
+	c.peers = append(c.peers, raddr)
+	c.mux.Unlock()
+
+	return nil
+}
+
+// attach binds the cluster to a limiter and makes it use the limiter clock for report timestamps and PeerTTL.
+func (c *Cluster) attach(clock Clock) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.attached {
+		return fmt.Errorf("already used by another limiter")
+	}
+	c.attached = true
+	c.clock = clock
+
+	return nil
+}
+
+func (c *Cluster) Close() error {
+	close(c.closeCh)
+	err := c.conn.Close()
+	c.wg.Wait()
+
+	return err
+}
+
+// ClusterPeer is the latest report received from a peer.
+type ClusterPeer struct {
+	Name       string
+	Successful int64
+	Congested  int64
+	Max        int64
+	ReceivedAt time.Time
+}
+
+// Peers returns the latest reports received from peers.
+func (c *Cluster) Peers() []ClusterPeer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	peers := make([]ClusterPeer, 0, len(c.reports))
+	for name, r := range c.reports {
+		peers = append(peers, ClusterPeer{
+			Name:       name,
+			Successful: r.successful,
+			Congested:  r.congested,
+			Max:        r.max,
+			ReceivedAt: r.at,
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+
+	return peers
+}
+
+// exchange queues the local period counts for sending to all peers and returns the sum of the fresh reports
+// received from them. Reports older than PeerTTL, or defaultTTL if it is not set, are dropped.
+func (c *Cluster) exchange(successful, congested, max int64, defaultTTL time.Duration) (peerSuccessful, peerCongested int64) {
+	msg := make([]byte, 0, 2+len(c.cfg.Name)+8*3)
+	msg = append(msg, clusterMsgVersion, byte(len(c.cfg.Name)))
+	msg = append(msg, c.cfg.Name...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(successful))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(congested))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(max))
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.out = msg
+	select {
+	case c.sendCh <- struct{}{}:
+	default:
+	}
+
+	ttl := c.cfg.PeerTTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+
+	now := c.clock.Now()
+	for name, r := range c.reports {
+		if now.Sub(r.at) > ttl {
+			delete(c.reports, name)
+			continue
+		}
+
+		peerSuccessful += r.successful
+		peerCongested += r.congested
+	}
+
+	return peerSuccessful, peerCongested
+}
+
+// send delivers the latest queued report to all peers. A report queued while the previous one is being sent replaces it.
+func (c *Cluster) send() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-c.sendCh:
+		}
+
+		c.mux.Lock()
+		msg := c.out
+		peers := c.peers
+		c.mux.Unlock()
+
+		for _, peer := range peers {
+			if _, err := c.conn.WriteToUDP(msg, peer); err != nil {
+				log.Printf("[ERROR] backpressure: cluster: send to %s: %s", peer, err)
+			}
+		}
+	}
+}
+
+func (c *Cluster) receive() {
+	defer c.wg.Done()
+
+	buf := make([]byte, 512)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-c.closeCh:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Printf("[ERROR] backpressure: cluster: receive: %s", err)
+			continue
+		}
+
+		name, r, err := decodeClusterMsg(buf[:n])
+		if err != nil {
+			log.Printf("[ERROR] backpressure: cluster: decode: %s", err)
+			continue
+		}
+		if name == c.cfg.Name {
+			continue
+		}
+
+		c.mux.Lock()
+		if !c.isPeer(from) {
+			c.mux.Unlock()
+			log.Printf("[ERROR] backpressure: cluster: report from unknown peer %s", from)
+			continue
+		}
+		r.at = c.clock.Now()
+		c.reports[name] = r
+		c.mux.Unlock()
+	}
+}
+
+// isPeer reports whether addr is one of the peers. It must be called with mux held.
+func (c *Cluster) isPeer(addr *net.UDPAddr) bool {
+	for _, peer := range c.peers {
+		if peer.Port == addr.Port && peer.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeClusterMsg(msg []byte) (string, clusterReport, error) {
+	if len(msg) < 2 {
+		return "", clusterReport{}, fmt.Errorf("message too short")
+	}
+	if msg[0] != clusterMsgVersion {
+		return "", clusterReport{}, fmt.Errorf("unsupported version %d", msg[0])
+	}
+
+	nameLen := int(msg[1])
+	if len(msg) != 2+nameLen+8*3 {
+		return "", clusterReport{}, fmt.Errorf("unexpected message length %d", len(msg))
+	}
+
+	name := string(msg[2 : 2+nameLen])
+	msg = msg[2+nameLen:]
+
+	return name, clusterReport{
+		successful: int64(binary.BigEndian.Uint64(msg[0:8])),
+		congested:  int64(binary.BigEndian.Uint64(msg[8:16])),
+		max:        int64(binary.BigEndian.Uint64(msg[16:24])),
+	}, nil
+}
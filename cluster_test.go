@@ -0,0 +1,208 @@
+package backpressure
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster(main *testing.T) {
+	newNode := func(t *testing.T, globalMax int64, clock Clock) *Backpreassure {
+		c, err := NewCluster(ClusterConfig{
+			Addr:      "127.0.0.1:0",
+			GlobalMax: globalMax,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, c.Close())
+		})
+
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 1000,
+			Max:    80,
+
+			Cluster: c,
+			Clock:   clock,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	connect := func(t *testing.T, nodes ...*Backpreassure) {
+		for _, n1 := range nodes {
+			for _, n2 := range nodes {
+				if n1 != n2 {
+					require.NoError(t, n1.cfg.Cluster.AddPeer(n2.cfg.Cluster.Addr().String()))
+				}
+			}
+		}
+	}
+
+	waitPeers := func(t *testing.T, bp *Backpreassure, n int) {
+		require.Eventually(t, func() bool {
+			return len(bp.cfg.Cluster.Peers()) == n
+		}, time.Second, time.Millisecond*10)
+	}
+
+	main.Run("AddrRequired", func(t *testing.T) {
+		c, err := NewCluster(ClusterConfig{})
+		require.EqualError(t, err, `Addr: required`)
+		require.Nil(t, c)
+	})
+
+	main.Run("DefaultName", func(t *testing.T) {
+		hostname, err := os.Hostname()
+		require.NoError(t, err)
+
+		c1, err := NewCluster(ClusterConfig{Addr: ":0"})
+		require.NoError(t, err)
+		defer c1.Close()
+		c2, err := NewCluster(ClusterConfig{Addr: ":0"})
+		require.NoError(t, err)
+		defer c2.Close()
+
+		_, port, err := net.SplitHostPort(c1.Addr().String())
+		require.NoError(t, err)
+		require.Equal(t, net.JoinHostPort(hostname, port), c1.cfg.Name)
+		require.NotEqual(t, c1.cfg.Name, c2.cfg.Name)
+	})
+
+	main.Run("UnknownSenderDropped", func(t *testing.T) {
+		bp1 := newNode(t, 0, nil)
+		bp2 := newNode(t, 0, nil)
+		connect(t, bp1, bp2)
+
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		msg := []byte{clusterMsgVersion, 1, 'x'}
+		msg = binary.BigEndian.AppendUint64(msg, 0)
+		msg = binary.BigEndian.AppendUint64(msg, 1000)
+		msg = binary.BigEndian.AppendUint64(msg, 1)
+		_, err = conn.WriteToUDP(msg, bp1.cfg.Cluster.Addr().(*net.UDPAddr))
+		require.NoError(t, err)
+
+		bp2.successful = 100
+		bp2.decide()
+		waitPeers(t, bp1, 1)
+
+		peers := bp1.cfg.Cluster.Peers()
+		require.Equal(t, bp2.cfg.Cluster.cfg.Name, peers[0].Name)
+	})
+
+	main.Run("SharedRejected", func(t *testing.T) {
+		bp := newNode(t, 0, nil)
+
+		bp2, err := New(Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+			Cluster:         bp.cfg.Cluster,
+		})
+		require.EqualError(t, err, `Cluster: already used by another limiter`)
+		require.Nil(t, bp2)
+
+		h, err := NewHierarchy(Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+		}, Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+			Cluster:         bp.cfg.Cluster,
+		})
+		require.EqualError(t, err, `Cluster: cannot be shared by children`)
+		require.Nil(t, h)
+	})
+
+	main.Run("ClusterCongestion", func(t *testing.T) {
+		bp1 := newNode(t, 0, nil)
+		bp2 := newNode(t, 0, nil)
+		bp3 := newNode(t, 0, nil)
+		connect(t, bp1, bp2, bp3)
+
+		bp2.successful = 10
+		bp2.congested = 90
+		bp2.decide()
+
+		bp3.successful = 10
+		bp3.congested = 90
+		bp3.decide()
+
+		waitPeers(t, bp1, 2)
+
+		peers := bp1.cfg.Cluster.Peers()
+		require.Equal(t, int64(10), peers[0].Successful)
+		require.Equal(t, int64(90), peers[0].Congested)
+		require.Equal(t, int64(80), peers[0].Max)
+
+		// locally there is no congestion at all, but the cluster is congested
+		bp1.successful = 100
+		bp1.decide()
+		require.Equal(t, int64(64), bp1.max)
+	})
+
+	main.Run("PeerTTL", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp1 := newNode(t, 0, clock)
+		bp2 := newNode(t, 0, nil)
+		connect(t, bp1, bp2)
+
+		bp2.successful = 10
+		bp2.congested = 90
+		bp2.decide()
+
+		waitPeers(t, bp1, 1)
+		require.Equal(t, time.Unix(1000, 0), bp1.cfg.Cluster.Peers()[0].ReceivedAt)
+
+		// the report is fresh by the limiter clock however much real time passes
+		clock.Advance(time.Hour * 3)
+		bp1.decide()
+		require.Len(t, bp1.cfg.Cluster.Peers(), 1)
+
+		clock.Advance(time.Nanosecond)
+		bp1.successful = 100
+		bp1.decide()
+		require.Equal(t, int64(89), bp1.max)
+		require.Empty(t, bp1.cfg.Cluster.Peers())
+	})
+
+	main.Run("GlobalMaxShare", func(t *testing.T) {
+		bp1 := newNode(t, 100, nil)
+		bp2 := newNode(t, 100, nil)
+		connect(t, bp1, bp2)
+
+		bp2.successful = 300
+		bp2.decide()
+
+		waitPeers(t, bp1, 1)
+
+		bp1.successful = 100
+		bp1.decide()
+		require.Equal(t, int64(25), bp1.max)
+	})
+}
+
+func TestDecodeClusterMsg(t *testing.T) {
+	_, _, err := decodeClusterMsg([]byte{1})
+	require.EqualError(t, err, `message too short`)
+
+	_, _, err = decodeClusterMsg([]byte{2, 0})
+	require.EqualError(t, err, `unsupported version 2`)
+
+	_, _, err = decodeClusterMsg([]byte{1, 3, 'a'})
+	require.EqualError(t, err, `unexpected message length 3`)
+}
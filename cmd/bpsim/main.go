@@ -0,0 +1,113 @@
+// Command bpsim runs a limiter against a modelled origin in simulated time and prints per-period statistics as CSV.
+// It helps to tune IncreasePercent, DecreasePercent and ThresholdPercent before rolling them out.
+//
+//	bpsim -rps 2000 -capacity 50 -latency 20ms -latency-dist exp -capacity-changes 60s=20,120s=50 -duration 3m
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/makasim/backpressure"
+)
+
+func main() {
+	lcfg := backpressure.Config{}
+	flag.DurationVar(&lcfg.DecidePeriod, "decide-period", time.Second, "limiter DecidePeriod")
+	flag.Float64Var(&lcfg.ThresholdPercent, "threshold", 0.01, "limiter ThresholdPercent")
+	flag.Float64Var(&lcfg.IncreasePercent, "increase", 0.02, "limiter IncreasePercent")
+	flag.Float64Var(&lcfg.DecreasePercent, "decrease", 0.2, "limiter DecreasePercent")
+	increaseFunc := flag.String("increase-func", "multiplicative", "limiter IncreaseFunc: multiplicative, additive, sqrt or cubic")
+	flag.Int64Var(&lcfg.IncreaseStep, "increase-step", 0, "limiter IncreaseStep")
+	flag.BoolVar(&lcfg.DecreaseProportional, "decrease-proportional", false, "limiter DecreaseProportional")
+	flag.Float64Var(&lcfg.DecreaseMinPercent, "decrease-min", 0, "limiter DecreaseMinPercent")
+	flag.Int64Var(&lcfg.MinMax, "min-max", 1, "limiter MinMax")
+	flag.Int64Var(&lcfg.MaxMax, "max-max", 0, "limiter MaxMax")
+	flag.Int64Var(&lcfg.Max, "max", 100, "limiter initial Max")
+	flag.DurationVar(&lcfg.SameLatency, "same-latency", 0, "limiter SameLatency")
+	flag.Float64Var(&lcfg.SameLatencyPercentile, "same-latency-percentile", 0, "limiter SameLatencyPercentile")
+	flag.DurationVar(&lcfg.DecreaseLatency, "decrease-latency", 0, "limiter DecreaseLatency")
+	flag.Float64Var(&lcfg.DecreaseLatencyPercentile, "decrease-latency-percentile", 0, "limiter DecreaseLatencyPercentile")
+	flag.Float64Var(&lcfg.EarlyDropPercent, "early-drop", 0, "limiter EarlyDropPercent")
+
+	origin := originModel{}
+	flag.Int64Var(&origin.Capacity, "capacity", 50, "number of requests the origin serves concurrently")
+	flag.DurationVar(&origin.Latency, "latency", time.Millisecond*20, "mean origin latency")
+	flag.StringVar(&origin.LatencyDist, "latency-dist", "exp", "origin latency distribution: const, uniform or exp")
+	flag.DurationVar(&origin.RejectLatency, "reject-latency", time.Millisecond, "time the origin needs to reject a request above its capacity")
+	flag.Float64Var(&origin.ErrorRate, "error-rate", 0, "probability of a served request to fail")
+	capacityChanges := flag.String("capacity-changes", "", "origin capacity changes in simulated time, for example 60s=20,120s=50")
+
+	rps := flag.Float64("rps", 1000, "client requests per second")
+	duration := flag.Duration("duration", time.Minute, "simulated duration")
+	seed := flag.Uint64("seed", 1, "random seed")
+	flag.Parse()
+	lcfg.IncreaseFunc = backpressure.IncreaseFunc(*increaseFunc)
+
+	changes, err := parseCapacityChanges(*capacityChanges)
+	if err != nil {
+		log.Fatalf("capacity-changes: %s", err)
+	}
+	origin.CapacityChanges = changes
+
+	w := csv.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if err := w.Write([]string{"time", "max", "in_flight", "successful", "congested", "denied"}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := simulate(simConfig{
+		Limiter:  lcfg,
+		Origin:   origin,
+		RPS:      *rps,
+		Duration: *duration,
+		Seed:     *seed,
+	}, func(r periodRow) {
+		if err := w.Write([]string{
+			strconv.FormatFloat(r.At.Seconds(), 'f', 3, 64),
+			strconv.FormatInt(r.Max, 10),
+			strconv.FormatInt(r.InFlight, 10),
+			strconv.FormatInt(r.Successful, 10),
+			strconv.FormatInt(r.Congested, 10),
+			strconv.FormatInt(r.Denied, 10),
+		}); err != nil {
+			log.Fatal(err)
+		}
+	}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseCapacityChanges(s string) ([]capacityChange, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var changes []capacityChange
+	for _, part := range strings.Split(s, ",") {
+		at, capacity, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected time=capacity", part)
+		}
+
+		d, err := time.ParseDuration(at)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", part, err)
+		}
+		c, err := strconv.ParseInt(capacity, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", part, err)
+		}
+
+		changes = append(changes, capacityChange{At: d, Capacity: c})
+	}
+
+	return changes, nil
+}
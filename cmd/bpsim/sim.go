@@ -0,0 +1,242 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/makasim/backpressure"
+)
+
+type originModel struct {
+	// Capacity is the number of requests the origin serves concurrently. Requests above it are rejected as congested.
+	Capacity int64
+	// Latency is the mean time the origin needs to serve a request.
+	Latency time.Duration
+	// LatencyDist is one of const, uniform (0.5-1.5 of Latency) or exp.
+	LatencyDist string
+	// RejectLatency is the time the origin needs to reject a request above its capacity.
+	RejectLatency time.Duration
+	// ErrorRate is a probability of a served request to fail as congested.
+	ErrorRate float64
+	// CapacityChanges change Capacity at the given simulated time.
+	CapacityChanges []capacityChange
+}
+
+type capacityChange struct {
+	At       time.Duration
+	Capacity int64
+}
+
+type simConfig struct {
+	Limiter  backpressure.Config
+	Origin   originModel
+	RPS      float64
+	Duration time.Duration
+	Seed     uint64
+}
+
+type periodRow struct {
+	At         time.Duration
+	Max        int64
+	InFlight   int64
+	Successful int64
+	Congested  int64
+	Denied     int64
+}
+
+type eventKind int
+
+// the order defines which of the events happening at the same time is handled first
+const (
+	completeEvent eventKind = iota
+	capacityEvent
+	arrivalEvent
+	periodEvent
+)
+
+type event struct {
+	at   time.Duration
+	kind eventKind
+	seq  int64
+
+	token     backpressure.Token
+	congested bool
+	served    bool
+	capacity  int64
+}
+
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	if q[i].kind != q[j].kind {
+		return q[i].kind < q[j].kind
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// simulation drives a limiter with generated traffic in simulated time, so hours of traffic take seconds to run.
+type simulation struct {
+	cfg   simConfig
+	clock *backpressure.ManualClock
+	epoch time.Time
+	bp    *backpressure.Backpreassure
+	rnd   *rand.Rand
+
+	q   eventQueue
+	seq int64
+
+	capacity   int64
+	originUsed int64
+	inFlight   int64
+	lastMax    int64
+
+	row periodRow
+}
+
+func simulate(cfg simConfig, emit func(periodRow)) error {
+	if cfg.RPS <= 0 {
+		return fmt.Errorf("rps: must be positive")
+	}
+	if cfg.Duration <= 0 {
+		return fmt.Errorf("duration: must be positive")
+	}
+	if cfg.Origin.Capacity <= 0 {
+		return fmt.Errorf("capacity: must be positive")
+	}
+
+	epoch := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := backpressure.NewManualClock(epoch)
+
+	cfg.Limiter.Clock = clock
+	bp, err := backpressure.New(cfg.Limiter)
+	if err != nil {
+		return err
+	}
+	defer bp.Close()
+
+	s := &simulation{
+		cfg:      cfg,
+		clock:    clock,
+		epoch:    epoch,
+		bp:       bp,
+		rnd:      rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		capacity: cfg.Origin.Capacity,
+	}
+
+	s.push(event{at: s.interarrival(), kind: arrivalEvent})
+	s.push(event{at: cfg.Limiter.DecidePeriod, kind: periodEvent})
+	for _, c := range cfg.Origin.CapacityChanges {
+		s.push(event{at: c.At, kind: capacityEvent, capacity: c.Capacity})
+	}
+
+	for s.q.Len() > 0 {
+		e := heap.Pop(&s.q).(event)
+		if e.at > cfg.Duration {
+			break
+		}
+
+		clock.Set(epoch.Add(e.at))
+
+		switch e.kind {
+		case arrivalEvent:
+			s.arrive(e.at)
+			s.push(event{at: e.at + s.interarrival(), kind: arrivalEvent})
+		case completeEvent:
+			s.complete(e)
+		case capacityEvent:
+			s.capacity = e.capacity
+		case periodEvent:
+			s.row.At = e.at
+			s.row.Max = s.lastMax
+			s.row.InFlight = s.inFlight
+			emit(s.row)
+
+			s.row = periodRow{}
+			s.push(event{at: e.at + cfg.Limiter.DecidePeriod, kind: periodEvent})
+		}
+	}
+
+	return nil
+}
+
+func (s *simulation) arrive(at time.Duration) {
+	t, allowed := s.bp.Acquire()
+	s.lastMax = t.Max
+	if !allowed {
+		s.row.Denied++
+		return
+	}
+
+	s.inFlight++
+
+	if s.originUsed >= s.capacity {
+		s.push(event{at: at + s.cfg.Origin.RejectLatency, kind: completeEvent, token: t, congested: true})
+		return
+	}
+
+	s.originUsed++
+	s.push(event{
+		at:        at + s.latency(),
+		kind:      completeEvent,
+		token:     t,
+		congested: s.rnd.Float64() < s.cfg.Origin.ErrorRate,
+		served:    true,
+	})
+}
+
+func (s *simulation) complete(e event) {
+	if e.served {
+		s.originUsed--
+	}
+	s.inFlight--
+
+	if e.congested {
+		s.row.Congested++
+	} else {
+		s.row.Successful++
+	}
+
+	e.token.Congested = e.congested
+	s.bp.Release(e.token)
+}
+
+func (s *simulation) push(e event) {
+	s.seq++
+	e.seq = s.seq
+	heap.Push(&s.q, e)
+}
+
+func (s *simulation) interarrival() time.Duration {
+	return time.Duration(s.rnd.ExpFloat64() / s.cfg.RPS * float64(time.Second))
+}
+
+func (s *simulation) latency() time.Duration {
+	l := float64(s.cfg.Origin.Latency)
+
+	switch s.cfg.Origin.LatencyDist {
+	case "uniform":
+		return time.Duration(l * (0.5 + s.rnd.Float64()))
+	case "exp":
+		return time.Duration(l * s.rnd.ExpFloat64())
+	default:
+		return time.Duration(l)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/makasim/backpressure"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulate(main *testing.T) {
+	cfg := func() simConfig {
+		return simConfig{
+			Limiter: backpressure.Config{
+				DecidePeriod:     time.Second,
+				ThresholdPercent: 0.01,
+				IncreasePercent:  0.02,
+				DecreasePercent:  0.2,
+				Max:              100,
+			},
+			Origin: originModel{
+				Capacity:      50,
+				Latency:       time.Millisecond * 20,
+				LatencyDist:   "exp",
+				RejectLatency: time.Millisecond,
+				CapacityChanges: []capacityChange{
+					{At: time.Second * 30, Capacity: 10},
+				},
+			},
+			RPS:      2000,
+			Duration: time.Minute,
+			Seed:     1,
+		}
+	}
+
+	run := func(t *testing.T, cfg simConfig) []periodRow {
+		var rows []periodRow
+		require.NoError(t, simulate(cfg, func(r periodRow) {
+			rows = append(rows, r)
+		}))
+
+		return rows
+	}
+
+	main.Run("Deterministic", func(t *testing.T) {
+		require.Equal(t, run(t, cfg()), run(t, cfg()))
+	})
+
+	main.Run("FollowsCapacity", func(t *testing.T) {
+		rows := run(t, cfg())
+		require.Len(t, rows, 60)
+
+		// the limit converges below the origin capacity and follows it when the capacity drops
+		require.LessOrEqual(t, rows[29].Max, int64(60))
+		require.LessOrEqual(t, rows[59].Max, int64(15))
+		require.Zero(t, rows[59].Congested)
+		require.Positive(t, rows[59].Denied)
+	})
+
+	main.Run("InvalidConfig", func(t *testing.T) {
+		c := cfg()
+		c.RPS = 0
+		require.EqualError(t, simulate(c, func(periodRow) {}), `rps: must be positive`)
+
+		c = cfg()
+		c.Limiter.DecidePeriod = 0
+		require.EqualError(t, simulate(c, func(periodRow) {}), `DecidePeriod: required`)
+	})
+}
+
+func TestParseCapacityChanges(t *testing.T) {
+	changes, err := parseCapacityChanges("10s=20,1m=5")
+	require.NoError(t, err)
+	require.Equal(t, []capacityChange{
+		{At: time.Second * 10, Capacity: 20},
+		{At: time.Minute, Capacity: 5},
+	}, changes)
+
+	_, err = parseCapacityChanges("10s")
+	require.EqualError(t, err, `"10s": expected time=capacity`)
+}
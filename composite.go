@@ -0,0 +1,79 @@
+package backpressure
+
+// Composite combines several limiters with AND semantics: a request is allowed only if every member allows it.
+// Members are acquired in the given order and once a member denies, tokens of the preceding members are canceled,
+// so the request counts neither as successful nor as congested there.
+type Composite struct {
+	limiters []Limiter
+}
+
+// CompositeToken holds a token of every member in the order of members.
+// Set Congested on it before Release, the outcome is passed to all members.
+type CompositeToken struct {
+	Tokens    []Token
+	Congested bool
+
+	// Denied is set if a member denied the request. DeniedBy is the index of the member and DenyReason is its reason.
+	Denied     bool
+	DeniedBy   int
+	DenyReason DenyReason
+}
+
+func NewComposite(limiters ...Limiter) *Composite {
+	return &Composite{
+		limiters: limiters,
+	}
+}
+
+func (c *Composite) Acquire() (CompositeToken, bool) {
+	ts := make([]Token, 0, len(c.limiters))
+	for i, l := range c.limiters {
+		t, allowed := l.Acquire()
+		if !allowed {
+			for j := len(ts) - 1; j >= 0; j-- {
+				c.limiters[j].Cancel(ts[j])
+			}
+
+			return CompositeToken{
+				Tokens:     append(ts, t),
+				Denied:     true,
+				DeniedBy:   i,
+				DenyReason: t.DenyReason,
+			}, false
+		}
+
+		ts = append(ts, t)
+	}
+
+	return CompositeToken{
+		Tokens:   ts,
+		DeniedBy: -1,
+	}, true
+}
+
+// Release passes the outcome of the request to every member. Releasing a denied token does nothing.
+func (c *Composite) Release(t CompositeToken) {
+	if t.Denied {
+		return
+	}
+
+	for i, l := range c.limiters {
+		if i >= len(t.Tokens) {
+			return
+		}
+
+		mt := t.Tokens[i]
+		mt.Congested = mt.Congested || t.Congested
+		l.Release(mt)
+	}
+}
+
+// Stats returns stats of every member in the order of members.
+func (c *Composite) Stats() []AIMDStats {
+	s := make([]AIMDStats, len(c.limiters))
+	for i, l := range c.limiters {
+		s[i] = l.Stats()
+	}
+
+	return s
+}
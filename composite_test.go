@@ -0,0 +1,124 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLimiter allows every request and records released and canceled tokens.
+type recordingLimiter struct {
+	used     int64
+	released []Token
+	canceled []Token
+}
+
+func (l *recordingLimiter) Acquire() (Token, bool) {
+	l.used++
+	return Token{Max: 1, Used: l.used}, true
+}
+
+func (l *recordingLimiter) Release(t Token) {
+	l.used--
+	l.released = append(l.released, t)
+}
+
+func (l *recordingLimiter) Cancel(t Token) {
+	l.used--
+	l.canceled = append(l.canceled, t)
+}
+
+func (l *recordingLimiter) Stats() AIMDStats {
+	return AIMDStats{Used: l.used}
+}
+
+func TestComposite(main *testing.T) {
+	newBP := func(t *testing.T, max int64) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    max,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	main.Run("AllAllow", func(t *testing.T) {
+		bp1 := newBP(t, 2)
+		bp2 := newBP(t, 2)
+		c := NewComposite(bp1, bp2)
+
+		tk, allowed := c.Acquire()
+		require.True(t, allowed)
+		require.False(t, tk.Denied)
+		require.Equal(t, -1, tk.DeniedBy)
+		require.Len(t, tk.Tokens, 2)
+
+		tk.Congested = true
+		c.Release(tk)
+
+		s := c.Stats()
+		require.Len(t, s, 2)
+		for _, ms := range s {
+			require.Equal(t, int64(0), ms.Used)
+			require.Equal(t, int64(1), ms.CongestedCounter)
+		}
+	})
+
+	main.Run("MemberDenies", func(t *testing.T) {
+		bp1 := newBP(t, 2)
+		bp2 := newBP(t, 1)
+		bp3 := newBP(t, 2)
+		c := NewComposite(bp1, bp2, bp3)
+
+		_, allowed := c.Acquire()
+		require.True(t, allowed)
+
+		tk, allowed := c.Acquire()
+		require.False(t, allowed)
+		require.True(t, tk.Denied)
+		require.Equal(t, 1, tk.DeniedBy)
+		require.Equal(t, DenyReasonOverLimit, tk.DenyReason)
+		require.Len(t, tk.Tokens, 2)
+
+		// the first member is rolled back without counting, the last one is not asked
+		require.Equal(t, int64(1), bp1.used)
+		require.Equal(t, int64(0), bp1.successful)
+		require.Equal(t, int64(1), bp2.denied)
+		require.Equal(t, int64(1), bp3.used)
+
+		c.Release(tk)
+		require.Equal(t, int64(1), bp1.used)
+	})
+
+	main.Run("RollbackCancels", func(t *testing.T) {
+		l := &recordingLimiter{}
+		bp := newBP(t, 1)
+		c := NewComposite(l, bp)
+
+		_, allowed := c.Acquire()
+		require.True(t, allowed)
+
+		_, allowed = c.Acquire()
+		require.False(t, allowed)
+		require.Equal(t, int64(1), l.used)
+		require.Len(t, l.canceled, 1)
+		require.Empty(t, l.released)
+	})
+
+	main.Run("NoMembers", func(t *testing.T) {
+		c := NewComposite()
+
+		tk, allowed := c.Acquire()
+		require.True(t, allowed)
+		c.Release(tk)
+		require.Empty(t, c.Stats())
+	})
+}
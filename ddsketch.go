@@ -0,0 +1,235 @@
+package backpressure
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// DDSketch is a dependency free LatencySketch with bounded relative error.
+// Values are counted in logarithmically sized buckets, so any quantile is within the configured relative accuracy
+// of the true value. Only buckets between the smallest and the largest recorded value are allocated,
+// which keeps a sketch at a few kilobytes for typical latency ranges.
+//
+// See https://arxiv.org/abs/1908.10693
+type DDSketch struct {
+	gamma    float64
+	logGamma float64
+
+	// bins[i] counts values whose bucket index is offset+i
+	bins   []int64
+	offset int
+
+	zeroCount int64
+	count     int64
+	min       int64
+	max       int64
+}
+
+// NewDDSketch creates a sketch with the given relative accuracy, for example 0.01 for 1%.
+// It panics if relativeAccuracy is not between 0 and 1, exclusive.
+func NewDDSketch(relativeAccuracy float64) *DDSketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		panic("backpressure: DDSketch: relative accuracy must be between 0 and 1")
+	}
+
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+
+	return &DDSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+	}
+}
+
+func (s *DDSketch) Record(v, n int64) error {
+	if v < 0 {
+		return fmt.Errorf("value %d is negative", v)
+	}
+	if n <= 0 {
+		return fmt.Errorf("count %d is not positive", n)
+	}
+
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if v > s.max {
+		s.max = v
+	}
+	s.count += n
+
+	if v == 0 {
+		s.zeroCount += n
+		return nil
+	}
+
+	idx := s.index(v)
+	s.grow(idx, idx)
+	s.bins[idx-s.offset] += n
+
+	return nil
+}
+
+func (s *DDSketch) Quantile(q float64) int64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	rank := int64(q * float64(s.count-1))
+	if rank < s.zeroCount {
+		return 0
+	}
+
+	cum := s.zeroCount
+	for i, c := range s.bins {
+		cum += c
+		if cum > rank {
+			return s.clamp(s.value(s.offset + i))
+		}
+	}
+
+	return s.max
+}
+
+func (s *DDSketch) Merge(other LatencySketch) error {
+	o, ok := other.(*DDSketch)
+	if !ok {
+		return fmt.Errorf("cannot merge %T into %T", other, s)
+	}
+	if o.gamma != s.gamma {
+		return fmt.Errorf("cannot merge sketches with different relative accuracy")
+	}
+	if o.count == 0 {
+		return nil
+	}
+
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.zeroCount += o.zeroCount
+
+	if len(o.bins) == 0 {
+		return nil
+	}
+
+	s.grow(o.offset, o.offset+len(o.bins)-1)
+	for i, c := range o.bins {
+		s.bins[o.offset+i-s.offset] += c
+	}
+
+	return nil
+}
+
+// Reset forgets all recorded values but keeps allocated buckets for reuse.
+func (s *DDSketch) Reset() {
+	clear(s.bins)
+	s.zeroCount = 0
+	s.count = 0
+	s.min = 0
+	s.max = 0
+}
+
+func (s *DDSketch) index(v int64) int {
+	return int(math.Ceil(math.Log(float64(v)) / s.logGamma))
+}
+
+func (s *DDSketch) value(idx int) int64 {
+	return int64(2 * math.Pow(s.gamma, float64(idx)) / (s.gamma + 1))
+}
+
+func (s *DDSketch) clamp(v int64) int64 {
+	if v < s.min {
+		return s.min
+	}
+	if v > s.max {
+		return s.max
+	}
+
+	return v
+}
+
+// grow makes sure bins cover bucket indexes from lo to hi inclusive.
+func (s *DDSketch) grow(lo, hi int) {
+	if len(s.bins) == 0 {
+		s.bins = make([]int64, hi-lo+1)
+		s.offset = lo
+		return
+	}
+
+	if lo < s.offset {
+		bins := make([]int64, s.offset-lo+len(s.bins))
+		copy(bins[s.offset-lo:], s.bins)
+		s.bins = bins
+		s.offset = lo
+	}
+
+	if last := s.offset + len(s.bins) - 1; hi > last {
+		s.bins = append(s.bins, make([]int64, hi-last)...)
+	}
+}
+
+// MarshalBinary encodes the recorded values, so they can be restored with UnmarshalBinary.
+func (s *DDSketch) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 8+binary.MaxVarintLen64*(6+len(s.bins)))
+	data = binary.BigEndian.AppendUint64(data, math.Float64bits(s.gamma))
+	data = binary.AppendVarint(data, s.zeroCount)
+	data = binary.AppendVarint(data, s.count)
+	data = binary.AppendVarint(data, s.min)
+	data = binary.AppendVarint(data, s.max)
+	data = binary.AppendVarint(data, int64(s.offset))
+	data = binary.AppendUvarint(data, uint64(len(s.bins)))
+	for _, c := range s.bins {
+		data = binary.AppendVarint(data, c)
+	}
+
+	return data, nil
+}
+
+func (s *DDSketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("malformed data")
+	}
+	if gamma := math.Float64frombits(binary.BigEndian.Uint64(data)); gamma != s.gamma {
+		return fmt.Errorf("relative accuracy does not match")
+	}
+
+	r := varintReader{data: data[8:]}
+	zeroCount := r.varint()
+	count := r.varint()
+	min := r.varint()
+	max := r.varint()
+	offset := r.varint()
+	n := r.uvarint()
+	if r.err != nil {
+		return r.err
+	}
+	if n > uint64(len(r.data)) {
+		return fmt.Errorf("malformed data")
+	}
+
+	bins := make([]int64, n)
+	for i := range bins {
+		bins[i] = r.varint()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	s.zeroCount = zeroCount
+	s.count = count
+	s.min = min
+	s.max = max
+	s.offset = int(offset)
+	s.bins = bins
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package backpressure
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDDSketch(main *testing.T) {
+	main.Run("InvalidAccuracy", func(t *testing.T) {
+		require.Panics(t, func() { NewDDSketch(0) })
+		require.Panics(t, func() { NewDDSketch(1) })
+	})
+
+	main.Run("Empty", func(t *testing.T) {
+		s := NewDDSketch(0.01)
+		require.Equal(t, int64(0), s.Quantile(0.5))
+	})
+
+	main.Run("Negative", func(t *testing.T) {
+		s := NewDDSketch(0.01)
+		require.EqualError(t, s.Record(-1, 1), `value -1 is negative`)
+	})
+
+	main.Run("RelativeError", func(t *testing.T) {
+		r := rand.New(rand.NewPCG(1, 2))
+
+		s := NewDDSketch(0.01)
+		values := make([]int64, 0, 10000)
+		for i := 0; i < 10000; i++ {
+			v := int64(r.ExpFloat64() * 1e7)
+			values = append(values, v)
+			require.NoError(t, s.Record(v, 1))
+		}
+		slices.Sort(values)
+
+		for _, q := range []float64{0.1, 0.5, 0.9, 0.99, 0.999} {
+			exp := values[int(q*float64(len(values)-1))]
+			require.InEpsilon(t, exp, s.Quantile(q), 0.01, "q=%v", q)
+		}
+		require.Equal(t, values[0], s.Quantile(0))
+		require.Equal(t, values[len(values)-1], s.Quantile(1))
+	})
+
+	main.Run("Zero", func(t *testing.T) {
+		s := NewDDSketch(0.01)
+		require.NoError(t, s.Record(0, 1))
+		require.NoError(t, s.Record(0, 1))
+		require.NoError(t, s.Record(1000, 1))
+
+		require.Equal(t, int64(0), s.Quantile(0.5))
+		require.Equal(t, int64(1000), s.Quantile(1))
+	})
+
+	main.Run("Count", func(t *testing.T) {
+		s := NewDDSketch(0.01)
+		require.EqualError(t, s.Record(1000, 0), `count 0 is not positive`)
+
+		require.NoError(t, s.Record(1000, 99))
+		require.NoError(t, s.Record(0, 1))
+		require.NoError(t, s.Record(5000, 1))
+
+		require.Equal(t, int64(0), s.Quantile(0))
+		require.InEpsilon(t, 1000, s.Quantile(0.5), 0.01)
+		require.InEpsilon(t, 1000, s.Quantile(0.98), 0.01)
+		require.Equal(t, int64(5000), s.Quantile(1))
+	})
+
+	main.Run("Merge", func(t *testing.T) {
+		s1 := NewDDSketch(0.01)
+		s2 := NewDDSketch(0.01)
+		for i := int64(1); i <= 100; i++ {
+			require.NoError(t, s1.Record(i*1000, 1))
+			require.NoError(t, s2.Record(i*1000000, 1))
+		}
+
+		require.NoError(t, s1.Merge(s2))
+		require.Equal(t, int64(1000), s1.Quantile(0))
+		require.Equal(t, int64(100000000), s1.Quantile(1))
+		require.InEpsilon(t, int64(50000), s1.Quantile(0.25), 0.01)
+		require.InEpsilon(t, int64(50000000), s1.Quantile(0.75), 0.01)
+	})
+
+	main.Run("MergeMismatch", func(t *testing.T) {
+		s := NewDDSketch(0.01)
+
+		require.EqualError(t, s.Merge(NewDDSketch(0.02)), `cannot merge sketches with different relative accuracy`)
+		require.EqualError(t, s.Merge(NewHDRSketch()), `cannot merge *backpressure.HDRSketch into *backpressure.DDSketch`)
+	})
+
+	main.Run("Reset", func(t *testing.T) {
+		s := NewDDSketch(0.01)
+		require.NoError(t, s.Record(1000, 1))
+		s.Reset()
+
+		require.Equal(t, int64(0), s.Quantile(1))
+		require.NoError(t, s.Record(500, 1))
+		require.Equal(t, int64(500), s.Quantile(0.5))
+	})
+}
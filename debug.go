@@ -0,0 +1,177 @@
+package backpressure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DebugHandler serves live state and recent decisions of registered limiters and lets operators override them at runtime.
+// Decisions are listed from the oldest to the newest. Mount it with the prefix stripped:
+//
+//	h := backpressure.NewDebugHandler()
+//	h.Register("origin", bp)
+//	http.Handle("/debug/backpressure/", http.StripPrefix("/debug/backpressure", h))
+//
+// Routes:
+//
+//	GET  /                     all limiters
+//	GET  /{name}               a single limiter
+//	POST /{name}/pin?max=N     fix max at N
+//	POST /{name}/unpin         let decisions change max again
+//	POST /{name}/reset         zero cumulative counters
+//	POST /{name}/decide        make a decision right away
+type DebugHandler struct {
+	mux      sync.RWMutex
+	limiters map[string]*Backpreassure
+
+	serveMux *http.ServeMux
+}
+
+func NewDebugHandler() *DebugHandler {
+	h := &DebugHandler{
+		limiters: make(map[string]*Backpreassure),
+		serveMux: http.NewServeMux(),
+	}
+
+	h.serveMux.HandleFunc("GET /{$}", h.list)
+	h.serveMux.HandleFunc("GET /{name}", h.get)
+	h.serveMux.HandleFunc("POST /{name}/pin", h.action(func(bp *Backpreassure, r *http.Request) error {
+		max, err := strconv.ParseInt(r.URL.Query().Get("max"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("max: %s", err)
+		}
+
+		return bp.Pin(max)
+	}))
+	h.serveMux.HandleFunc("POST /{name}/unpin", h.action(func(bp *Backpreassure, _ *http.Request) error {
+		bp.Unpin()
+		return nil
+	}))
+	h.serveMux.HandleFunc("POST /{name}/reset", h.action(func(bp *Backpreassure, _ *http.Request) error {
+		bp.ResetStats()
+		return nil
+	}))
+	h.serveMux.HandleFunc("POST /{name}/decide", h.action(func(bp *Backpreassure, _ *http.Request) error {
+		bp.Decide()
+		return nil
+	}))
+	h.serveMux.HandleFunc("POST /{name}/warmup", h.action(func(bp *Backpreassure, _ *http.Request) error {
+		if bp.cfg.WarmUp <= 0 {
+			return fmt.Errorf("WarmUp: not configured")
+		}
+
+		bp.WarmUp()
+		return nil
+	}))
+
+	return h
+}
+
+// Register adds a limiter under the name, replacing a limiter registered under the same name.
+func (h *DebugHandler) Register(name string, bp *Backpreassure) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.limiters[name] = bp
+}
+
+func (h *DebugHandler) Unregister(name string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	delete(h.limiters, name)
+}
+
+func (h *DebugHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.serveMux.ServeHTTP(rw, r)
+}
+
+type debugLimiter struct {
+	Name      string     `json:"name"`
+	Pinned    int64      `json:"pinned,omitempty"`
+	Stats     AIMDStats  `json:"stats"`
+	Decisions []Decision `json:"decisions,omitempty"`
+	Config    Config     `json:"config"`
+}
+
+func newDebugLimiter(name string, bp *Backpreassure) debugLimiter {
+	dl := debugLimiter{
+		Name:      name,
+		Pinned:    bp.Pinned(),
+		Stats:     bp.Stats(),
+		Decisions: bp.DecisionHistory(),
+		Config:    bp.Config(),
+	}
+
+	return dl
+}
+
+func (h *DebugHandler) list(rw http.ResponseWriter, _ *http.Request) {
+	h.mux.RLock()
+	names := make([]string, 0, len(h.limiters))
+	for name := range h.limiters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dls := make([]debugLimiter, 0, len(names))
+	for _, name := range names {
+		dls = append(dls, newDebugLimiter(name, h.limiters[name]))
+	}
+	h.mux.RUnlock()
+
+	writeDebugJSON(rw, http.StatusOK, dls)
+}
+
+func (h *DebugHandler) get(rw http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	bp := h.limiter(name)
+	if bp == nil {
+		writeDebugError(rw, http.StatusNotFound, fmt.Errorf("limiter %q: not found", name))
+		return
+	}
+
+	writeDebugJSON(rw, http.StatusOK, newDebugLimiter(name, bp))
+}
+
+func (h *DebugHandler) action(fn func(bp *Backpreassure, r *http.Request) error) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		bp := h.limiter(name)
+		if bp == nil {
+			writeDebugError(rw, http.StatusNotFound, fmt.Errorf("limiter %q: not found", name))
+			return
+		}
+
+		if err := fn(bp, r); err != nil {
+			writeDebugError(rw, http.StatusBadRequest, err)
+			return
+		}
+
+		writeDebugJSON(rw, http.StatusOK, newDebugLimiter(name, bp))
+	}
+}
+
+func (h *DebugHandler) limiter(name string) *Backpreassure {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	return h.limiters[name]
+}
+
+func writeDebugJSON(rw http.ResponseWriter, code int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeDebugError(rw http.ResponseWriter, code int, err error) {
+	writeDebugJSON(rw, code, map[string]string{"error": err.Error()})
+}
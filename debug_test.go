@@ -0,0 +1,176 @@
+package backpressure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugHandler(main *testing.T) {
+	setUp := func(t *testing.T) (*Backpreassure, *httptest.Server) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    50,
+
+			DecreaseLatencyPercentile: 0.99,
+			DecreaseLatency:           time.Second,
+		})
+		require.NoError(t, err)
+
+		h := NewDebugHandler()
+		h.Register("origin", bp)
+
+		mux := http.NewServeMux()
+		mux.Handle("/debug/backpressure/", http.StripPrefix("/debug/backpressure", h))
+
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+
+		return bp, srv
+	}
+
+	do := func(t *testing.T, method, url string, wantCode int, v any) {
+		req, err := http.NewRequest(method, url, http.NoBody)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, wantCode, resp.StatusCode)
+		if v != nil {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+	}
+
+	main.Run("List", func(t *testing.T) {
+		bp, srv := setUp(t)
+		bp.lat.record(time.Millisecond.Nanoseconds(), 1)
+
+		var dls []debugLimiter
+		do(t, "GET", srv.URL+"/debug/backpressure/", http.StatusOK, &dls)
+		require.Len(t, dls, 1)
+		require.Equal(t, "origin", dls[0].Name)
+		require.Equal(t, int64(50), dls[0].Stats.Max)
+		require.Equal(t, int64(50), dls[0].Config.Max)
+		require.Equal(t, int64(100), dls[0].Config.MaxMax)
+		require.InEpsilon(t, time.Millisecond, dls[0].Stats.LatencyMax, 0.07)
+	})
+
+	main.Run("ConfigWithClock", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:    time.Hour,
+			IncreasePercent: 0.1,
+			DecreasePercent: 0.2,
+			Max:             50,
+			Clock:           NewManualClock(time.Unix(1000, 0)),
+		})
+		require.NoError(t, err)
+
+		h := NewDebugHandler()
+		h.Register("origin", bp)
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "/origin", http.NoBody))
+		require.Equal(t, http.StatusOK, rw.Code)
+
+		var dl debugLimiter
+		require.NoError(t, json.Unmarshal(rw.Body.Bytes(), &dl))
+		require.Equal(t, time.Hour, dl.Config.DecidePeriod)
+		require.Nil(t, dl.Config.Clock)
+		require.Nil(t, dl.Config.NewLatencySketch)
+	})
+
+	main.Run("NotFound", func(t *testing.T) {
+		_, srv := setUp(t)
+
+		do(t, "GET", srv.URL+"/debug/backpressure/unknown", http.StatusNotFound, nil)
+		do(t, "POST", srv.URL+"/debug/backpressure/unknown/decide", http.StatusNotFound, nil)
+	})
+
+	main.Run("PinUnpin", func(t *testing.T) {
+		bp, srv := setUp(t)
+
+		var dl debugLimiter
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/pin?max=10", http.StatusOK, &dl)
+		require.Equal(t, int64(10), dl.Pinned)
+		require.Equal(t, int64(10), bp.max)
+
+		bp.successful = 50
+		bp.congested = 50
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/decide", http.StatusOK, &dl)
+		require.Equal(t, int64(10), dl.Stats.Max)
+		require.Equal(t, int64(1), dl.Stats.DecideDecreaseCounter)
+
+		dl = debugLimiter{}
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/unpin", http.StatusOK, &dl)
+		require.Equal(t, int64(0), dl.Pinned)
+
+		bp.successful = 50
+		bp.congested = 50
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/decide", http.StatusOK, &dl)
+		require.Equal(t, int64(8), dl.Stats.Max)
+	})
+
+	main.Run("PinInvalid", func(t *testing.T) {
+		_, srv := setUp(t)
+
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/pin?max=abc", http.StatusBadRequest, nil)
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/pin?max=0", http.StatusBadRequest, nil)
+	})
+
+	main.Run("Reset", func(t *testing.T) {
+		bp, srv := setUp(t)
+		bp.successful = 50
+		bp.usedMax = 20
+		bp.decide()
+
+		var dl debugLimiter
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/reset", http.StatusOK, &dl)
+		require.Equal(t, int64(0), dl.Stats.SuccessfulCounter)
+		require.Equal(t, int64(0), dl.Stats.DecideIncreaseCounter)
+		require.Equal(t, bp.max, dl.Stats.Max)
+		require.Equal(t, int64(0), bp.usedMax)
+	})
+
+	main.Run("WarmUp", func(t *testing.T) {
+		_, srv := setUp(t)
+		do(t, "POST", srv.URL+"/debug/backpressure/origin/warmup", http.StatusBadRequest, nil)
+
+		bp, err := New(Config{DecidePeriod: time.Hour, IncreasePercent: 0.1, DecreasePercent: 0.2, MinMax: 1, Max: 50, WarmUp: time.Hour})
+		require.NoError(t, err)
+
+		h := NewDebugHandler()
+		h.Register("origin", bp)
+
+		bp.warmUpEndAt = 0
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("POST", "/origin/warmup", http.NoBody))
+		require.Equal(t, http.StatusOK, rw.Code)
+		require.Less(t, bp.effectiveMax(), int64(50))
+	})
+
+	main.Run("Unregister", func(t *testing.T) {
+		bp, err := New(Config{DecidePeriod: time.Hour, IncreasePercent: 0.1, DecreasePercent: 0.2})
+		require.NoError(t, err)
+
+		h := NewDebugHandler()
+		h.Register("origin", bp)
+		h.Unregister("origin")
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "/", http.NoBody))
+		require.Equal(t, http.StatusOK, rw.Code)
+		require.JSONEq(t, "[]", rw.Body.String())
+	})
+}
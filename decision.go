@@ -0,0 +1,97 @@
+package backpressure
+
+import (
+	"sync"
+	"time"
+)
+
+type DecisionRule string
+
+const (
+	DecisionIncrease           DecisionRule = "increase"
+	DecisionSameCongestion     DecisionRule = "same_congestion"
+	DecisionSameLatency        DecisionRule = "same_latency"
+	DecisionDecreaseCongestion DecisionRule = "decrease_congestion"
+	DecisionDecreaseLatency    DecisionRule = "decrease_latency"
+)
+
+// Decision describes a single capacity decision and the inputs it was made on.
+type Decision struct {
+	Time   time.Time
+	OldMax int64
+	NewMax int64
+
+	// Successful, Congested and Denied count local requests within the period.
+	Successful int64
+	Congested  int64
+	Denied     int64
+	// CongestedPercent is the congestion ratio the decision was made on, cluster peers included.
+	CongestedPercent float64
+
+	// SameLatency and DecreaseLatency are latencies at SameLatencyPercentile and DecreaseLatencyPercentile.
+	// They are zero if the corresponding percentile is not configured.
+	SameLatency     time.Duration
+	DecreaseLatency time.Duration
+
+	Rule DecisionRule
+	// ClusterCapped is set if max was lowered to the local share of the cluster GlobalMax.
+	ClusterCapped bool
+	// Pinned is set if max was kept at the pinned value regardless of the rule.
+	Pinned bool
+	// Rate is the rate limit after the decision. It is zero if Rate is not configured.
+	Rate float64
+	// DecreasePercent is the fraction max was decreased by. It is zero unless the rule is a decrease.
+	DecreasePercent float64
+}
+
+// decisionRing keeps the last decisions, overwriting the oldest one when full.
+type decisionRing struct {
+	mux  sync.Mutex
+	buf  []Decision
+	next int
+	full bool
+}
+
+func newDecisionRing(size int) *decisionRing {
+	return &decisionRing{
+		buf: make([]Decision, size),
+	}
+}
+
+func (r *decisionRing) add(d Decision) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.buf[r.next] = d
+	r.next++
+	if r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// list returns decisions from the oldest to the newest.
+func (r *decisionRing) list() []Decision {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if !r.full {
+		return append([]Decision(nil), r.buf[:r.next]...)
+	}
+
+	ds := make([]Decision, 0, len(r.buf))
+	ds = append(ds, r.buf[r.next:]...)
+	ds = append(ds, r.buf[:r.next]...)
+
+	return ds
+}
+
+// DecisionHistory returns the last DecisionHistorySize decisions from the oldest to the newest.
+// Periods without traffic make no decision and are not recorded.
+func (bp *Backpreassure) DecisionHistory() []Decision {
+	if bp.decisions == nil {
+		return nil
+	}
+
+	return bp.decisions.list()
+}
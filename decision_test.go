@@ -0,0 +1,145 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionHistory(main *testing.T) {
+	setUp := func(t *testing.T, size int) (*Backpreassure, *ManualClock) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp, err := New(Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    50,
+
+			SameLatencyPercentile:     0.5,
+			SameLatency:               time.Millisecond * 10,
+			DecreaseLatencyPercentile: 0.9,
+			DecreaseLatency:           time.Millisecond * 100,
+
+			DecisionHistorySize: size,
+			Clock:               clock,
+		})
+		require.NoError(t, err)
+
+		return bp, clock
+	}
+
+	main.Run("Rules", func(t *testing.T) {
+		bp, clock := setUp(t, 0)
+
+		bp.successful = 100
+		bp.denied = 3
+		bp.decide()
+
+		clock.Advance(time.Second)
+		bp.successful = 95
+		bp.congested = 5
+		bp.decide()
+
+		clock.Advance(time.Second)
+		bp.successful = 50
+		bp.congested = 50
+		bp.decide()
+
+		clock.Advance(time.Second)
+		for i := 0; i < 100; i++ {
+			bp.lat.record((time.Millisecond * 20).Nanoseconds(), 1)
+		}
+		bp.successful = 100
+		bp.decide()
+
+		clock.Advance(time.Second)
+		for i := 0; i < 100; i++ {
+			bp.lat.record((time.Millisecond * 200).Nanoseconds(), 1)
+		}
+		bp.successful = 100
+		bp.decide()
+
+		ds := bp.DecisionHistory()
+		require.Len(t, ds, 5)
+
+		require.Equal(t, DecisionIncrease, ds[0].Rule)
+		require.Equal(t, time.Unix(1000, 0), ds[0].Time)
+		require.Equal(t, int64(50), ds[0].OldMax)
+		require.Equal(t, int64(56), ds[0].NewMax)
+		require.Equal(t, int64(100), ds[0].Successful)
+		require.Equal(t, int64(3), ds[0].Denied)
+		require.Equal(t, float64(0), ds[0].CongestedPercent)
+
+		require.Equal(t, DecisionSameCongestion, ds[1].Rule)
+		require.Equal(t, int64(56), ds[1].OldMax)
+		require.Equal(t, int64(56), ds[1].NewMax)
+		require.Equal(t, int64(5), ds[1].Congested)
+		require.Equal(t, 0.05, ds[1].CongestedPercent)
+
+		require.Equal(t, DecisionDecreaseCongestion, ds[2].Rule)
+		require.Equal(t, int64(56), ds[2].OldMax)
+		require.Equal(t, int64(45), ds[2].NewMax)
+		require.Equal(t, 0.5, ds[2].CongestedPercent)
+
+		require.Equal(t, DecisionSameLatency, ds[3].Rule)
+		require.InEpsilon(t, time.Millisecond*20, ds[3].SameLatency, 0.07)
+		require.InEpsilon(t, time.Millisecond*20, ds[3].DecreaseLatency, 0.07)
+
+		require.Equal(t, DecisionDecreaseLatency, ds[4].Rule)
+		require.InEpsilon(t, time.Millisecond*200, ds[4].DecreaseLatency, 0.07)
+		require.Equal(t, time.Unix(1004, 0), ds[4].Time)
+	})
+
+	main.Run("NoTraffic", func(t *testing.T) {
+		bp, _ := setUp(t, 0)
+
+		bp.decide()
+		require.Empty(t, bp.DecisionHistory())
+	})
+
+	main.Run("Pinned", func(t *testing.T) {
+		bp, _ := setUp(t, 0)
+		require.NoError(t, bp.Pin(30))
+
+		bp.successful = 100
+		bp.decide()
+
+		ds := bp.DecisionHistory()
+		require.Len(t, ds, 1)
+		require.Equal(t, DecisionIncrease, ds[0].Rule)
+		require.True(t, ds[0].Pinned)
+		require.Equal(t, int64(30), ds[0].OldMax)
+		require.Equal(t, int64(30), ds[0].NewMax)
+	})
+
+	main.Run("Wraps", func(t *testing.T) {
+		bp, clock := setUp(t, 3)
+
+		for i := 0; i < 5; i++ {
+			bp.successful = 100
+			bp.decide()
+			clock.Advance(time.Second)
+		}
+
+		ds := bp.DecisionHistory()
+		require.Len(t, ds, 3)
+		require.Equal(t, time.Unix(1002, 0), ds[0].Time)
+		require.Equal(t, time.Unix(1003, 0), ds[1].Time)
+		require.Equal(t, time.Unix(1004, 0), ds[2].Time)
+		require.Equal(t, ds[0].NewMax, ds[1].OldMax)
+		require.Equal(t, ds[1].NewMax, ds[2].OldMax)
+	})
+
+	main.Run("Disabled", func(t *testing.T) {
+		bp, _ := setUp(t, -1)
+
+		bp.successful = 100
+		bp.decide()
+		require.Nil(t, bp.DecisionHistory())
+	})
+}
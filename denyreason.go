@@ -0,0 +1,105 @@
+package backpressure
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// DenyReason tells why Acquire denied a token.
+type DenyReason uint8
+
+const (
+	DenyReasonNone DenyReason = iota
+	// DenyReasonOverLimit is returned when the used capacity reached max.
+	DenyReasonOverLimit
+	// DenyReasonShutdown is returned after the limiter is closed.
+	DenyReasonShutdown
+	// DenyReasonRateLimit is returned when requests come faster than Config.Rate allows.
+	DenyReasonRateLimit
+	// DenyReasonQueueFull is returned by AcquireWait when QueueSize waiters are already queued.
+	DenyReasonQueueFull
+	// DenyReasonQueueDrop is returned by AcquireWait when CoDel drops a waiter queued for too long.
+	DenyReasonQueueDrop
+	// DenyReasonContextDone is returned by AcquireWait when the context is done before capacity is available.
+	DenyReasonContextDone
+	// DenyReasonCircuitOpen is returned while the circuit breaker is open or all half-open probes are in flight.
+	DenyReasonCircuitOpen
+	// DenyReasonThrottled is returned when adaptive throttling rejects a request locally, see Config.ThrottleK.
+	DenyReasonThrottled
+	// DenyReasonEarlyDrop is returned when a request is denied at random close to max, see Config.EarlyDropPercent.
+	DenyReasonEarlyDrop
+	// DenyReasonPriority is returned when the request priority may not use more of max, see Config.DefaultPriorityPercent.
+	DenyReasonPriority
+	// DenyReasonTenantShare is returned by AcquireTenant when the tenant used its share and there is no idle capacity to borrow.
+	DenyReasonTenantShare
+	// DenyReasonPartition is returned when the partition used its guarantee and other partitions hold the rest of max, see Config.Partitions.
+	DenyReasonPartition
+
+	denyReasonCount
+)
+
+var denyReasonNames = [denyReasonCount]string{
+	DenyReasonNone:        "none",
+	DenyReasonOverLimit:   "over_limit",
+	DenyReasonShutdown:    "shutdown",
+	DenyReasonRateLimit:   "rate_limit",
+	DenyReasonQueueFull:   "queue_full",
+	DenyReasonQueueDrop:   "queue_drop",
+	DenyReasonContextDone: "context_done",
+	DenyReasonCircuitOpen: "circuit_open",
+	DenyReasonThrottled:   "throttled",
+	DenyReasonEarlyDrop:   "early_drop",
+	DenyReasonPriority:    "priority",
+	DenyReasonTenantShare: "tenant_share",
+	DenyReasonPartition:   "partition",
+}
+
+func (r DenyReason) String() string {
+	if r >= denyReasonCount {
+		return fmt.Sprintf("DenyReason(%d)", r)
+	}
+
+	return denyReasonNames[r]
+}
+
+func (r DenyReason) MarshalText() ([]byte, error) {
+	if r >= denyReasonCount {
+		return nil, fmt.Errorf("unknown deny reason %d", r)
+	}
+
+	return []byte(denyReasonNames[r]), nil
+}
+
+func (r *DenyReason) UnmarshalText(text []byte) error {
+	for i, name := range denyReasonNames {
+		if name == string(text) {
+			*r = DenyReason(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown deny reason %q", text)
+}
+
+// deny counts a denial within the period, by the reason and by the priority.
+func (bp *Backpreassure) deny(t Token) {
+	atomic.AddInt64(&bp.denied, 1)
+	atomic.AddInt64(&bp.deniedBy[t.DenyReason], 1)
+	if pc := bp.priorityCounters(t.Priority); pc != nil {
+		atomic.AddInt64(&pc.denied, 1)
+	}
+}
+
+func (bp *Backpreassure) deniedReasons() map[DenyReason]int64 {
+	var rs map[DenyReason]int64
+	for i := range bp.deniedBy {
+		if n := atomic.LoadInt64(&bp.deniedBy[i]); n > 0 {
+			if rs == nil {
+				rs = make(map[DenyReason]int64)
+			}
+			rs[DenyReason(i)] = n
+		}
+	}
+
+	return rs
+}
@@ -0,0 +1,243 @@
+package backpressure
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hierarchyIdlePeriods defines after how many child decide periods without requests a child is closed and forgotten.
+const hierarchyIdlePeriods = 10
+
+// Hierarchy puts per-key child limiters under a single parent limiter, for example per-endpoint limits
+// under a limit for the whole upstream. A request needs a token from both its child and the parent.
+// Children are created on first use of a key and share the child config, which cannot set a Cluster.
+// Children without requests in flight are closed once idle for hierarchyIdlePeriods child decide periods,
+// a key used again later gets a new child which starts from the child config.
+type Hierarchy struct {
+	parent   *Backpreassure
+	childCfg Config
+
+	mux      sync.RWMutex
+	children map[string]*hierarchyChild
+	closed   bool
+	// evictedAt is when idle children were looked for last time, guarded by mux
+	evictedAt time.Time
+}
+
+type hierarchyChild struct {
+	bp *Backpreassure
+	// lastUsedAt is the UnixNano time the child was last handed out for a request
+	lastUsedAt int64
+}
+
+// HierarchyToken embeds the child token and carries the parent one.
+// Set Congested on it before Release, the outcome is passed to both limiters.
+type HierarchyToken struct {
+	Token
+
+	Key    string
+	Parent Token
+}
+
+type HierarchyStats struct {
+	Parent   AIMDStats
+	Children map[string]AIMDStats
+}
+
+func NewHierarchy(parentCfg, childCfg Config) (*Hierarchy, error) {
+	if err := validateAIMDConfig(childCfg); err != nil {
+		return nil, err
+	}
+	if childCfg.Cluster != nil {
+		return nil, fmt.Errorf("Cluster: cannot be shared by children")
+	}
+	if childCfg.LatencyShards == 0 {
+		// there may be many children, each shard costs 3 sketches
+		childCfg.LatencyShards = 1
+	}
+	if childCfg.Clock == nil {
+		// children and the idle eviction must agree on time
+		childCfg.Clock = realClock{}
+	}
+
+	parent, err := New(parentCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Hierarchy{
+		parent:    parent,
+		childCfg:  childCfg,
+		children:  make(map[string]*hierarchyChild),
+		evictedAt: childCfg.Clock.Now(),
+	}, nil
+}
+
+// Acquire takes a token from the child of the key and then from the parent.
+// If the parent denies, the child token is canceled and the returned token carries the parent deny reason.
+func (h *Hierarchy) Acquire(key string) (HierarchyToken, bool) {
+	child := h.child(key)
+	if child == nil {
+		return HierarchyToken{
+			Token: deniedToken(0, 0, DenyReasonShutdown),
+			Key:   key,
+		}, false
+	}
+
+	ct, allowed := child.Acquire()
+	if !allowed {
+		return HierarchyToken{Token: ct, Key: key}, false
+	}
+
+	pt, allowed := h.parent.Acquire()
+	if !allowed {
+		child.Cancel(ct)
+
+		ct.Denied = true
+		ct.DenyReason = pt.DenyReason
+		return HierarchyToken{Token: ct, Key: key, Parent: pt}, false
+	}
+
+	return HierarchyToken{Token: ct, Key: key, Parent: pt}, true
+}
+
+// Release passes the outcome of the request to the child and the parent. Releasing a denied token does nothing.
+func (h *Hierarchy) Release(t HierarchyToken) {
+	if t.Denied {
+		return
+	}
+
+	h.mux.RLock()
+	child := h.children[t.Key]
+	h.mux.RUnlock()
+
+	t.Parent.Congested = t.Congested
+	h.parent.Release(t.Parent)
+	// a child with a request in flight is never evicted
+	if child != nil {
+		child.bp.Release(t.Token)
+	}
+}
+
+// Parent returns the parent limiter.
+func (h *Hierarchy) Parent() *Backpreassure {
+	return h.parent
+}
+
+// Child returns the child limiter of the key or nil if the key has not been used yet or its child has been evicted.
+func (h *Hierarchy) Child(key string) *Backpreassure {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	if child, ok := h.children[key]; ok {
+		return child.bp
+	}
+
+	return nil
+}
+
+func (h *Hierarchy) Stats() HierarchyStats {
+	h.mux.RLock()
+	children := make(map[string]*Backpreassure, len(h.children))
+	for key, child := range h.children {
+		children[key] = child.bp
+	}
+	h.mux.RUnlock()
+
+	s := HierarchyStats{
+		Parent:   h.parent.Stats(),
+		Children: make(map[string]AIMDStats, len(children)),
+	}
+	for key, child := range children {
+		s.Children[key] = child.Stats()
+	}
+
+	return s
+}
+
+// Close closes the parent and all children. Acquire denies with DenyReasonShutdown afterwards.
+func (h *Hierarchy) Close() error {
+	h.mux.Lock()
+	h.closed = true
+	children := h.children
+	h.mux.Unlock()
+
+	for _, child := range children {
+		child.bp.Close()
+	}
+
+	return h.parent.Close()
+}
+
+// child returns the child of the key, creating it if needed. It returns nil once the hierarchy is closed.
+// Idle children are evicted when a new one is created, so the number of children follows the number of recently used keys.
+func (h *Hierarchy) child(key string) *Backpreassure {
+	now := h.childCfg.Clock.Now()
+
+	// evictIdle holds the write lock, so a child handed out here is either already gone or seen by it as used just now
+	h.mux.RLock()
+	child, ok := h.children[key]
+	if ok {
+		atomic.StoreInt64(&child.lastUsedAt, now.UnixNano())
+	}
+	h.mux.RUnlock()
+	if ok {
+		return child.bp
+	}
+
+	h.mux.Lock()
+	if h.closed {
+		h.mux.Unlock()
+		return nil
+	}
+	if child, ok := h.children[key]; ok {
+		atomic.StoreInt64(&child.lastUsedAt, now.UnixNano())
+		h.mux.Unlock()
+		return child.bp
+	}
+
+	evicted := h.evictIdle(now)
+
+	bp, err := New(h.childCfg)
+	if err != nil {
+		h.mux.Unlock()
+		// the config has been validated by NewHierarchy
+		log.Printf("[ERROR] backpressure: hierarchy: new child: %s", err)
+		return nil
+	}
+	h.children[key] = &hierarchyChild{
+		bp:         bp,
+		lastUsedAt: now.UnixNano(),
+	}
+	h.mux.Unlock()
+
+	for _, child := range evicted {
+		child.Close()
+	}
+
+	return bp
+}
+
+// evictIdle forgets children idle for hierarchyIdlePeriods and returns them to be closed.
+// It looks for them at most once per child decide period. It must be called with mux held.
+func (h *Hierarchy) evictIdle(now time.Time) []*Backpreassure {
+	if now.Sub(h.evictedAt) < h.childCfg.DecidePeriod {
+		return nil
+	}
+	h.evictedAt = now
+
+	idleSince := now.Add(-h.childCfg.DecidePeriod * hierarchyIdlePeriods).UnixNano()
+
+	var evicted []*Backpreassure
+	for key, child := range h.children {
+		if atomic.LoadInt64(&child.lastUsedAt) < idleSince && atomic.LoadInt64(&child.bp.used) == 0 {
+			delete(h.children, key)
+			evicted = append(evicted, child.bp)
+		}
+	}
+
+	return evicted
+}
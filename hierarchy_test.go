@@ -0,0 +1,203 @@
+package backpressure
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHierarchy(main *testing.T) {
+	setUp := func(t *testing.T) *Hierarchy {
+		h, err := NewHierarchy(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    3,
+		}, Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    2,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, h.Close())
+		})
+
+		return h
+	}
+
+	main.Run("InvalidConfig", func(t *testing.T) {
+		h, err := NewHierarchy(Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+		}, Config{})
+		require.EqualError(t, err, `DecidePeriod: required`)
+		require.Nil(t, h)
+
+		h, err = NewHierarchy(Config{}, Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+		})
+		require.EqualError(t, err, `DecidePeriod: required`)
+		require.Nil(t, h)
+	})
+
+	main.Run("ChildDenies", func(t *testing.T) {
+		h := setUp(t)
+
+		_, allowed := h.Acquire("a")
+		require.True(t, allowed)
+		_, allowed = h.Acquire("a")
+		require.True(t, allowed)
+
+		tk, allowed := h.Acquire("a")
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonOverLimit, tk.DenyReason)
+		require.Equal(t, "a", tk.Key)
+		require.Equal(t, int64(2), h.Parent().used)
+		require.Equal(t, int64(0), h.Parent().denied)
+		require.Equal(t, int64(1), h.Child("a").denied)
+	})
+
+	main.Run("ParentDeniesAndChildIsRolledBack", func(t *testing.T) {
+		h := setUp(t)
+
+		h.Acquire("a")
+		h.Acquire("a")
+		_, allowed := h.Acquire("b")
+		require.True(t, allowed)
+
+		tk, allowed := h.Acquire("b")
+		require.False(t, allowed)
+		require.True(t, tk.Denied)
+		require.Equal(t, DenyReasonOverLimit, tk.DenyReason)
+		require.Equal(t, DenyReasonOverLimit, tk.Parent.DenyReason)
+
+		require.Equal(t, int64(1), h.Child("b").used)
+		require.Equal(t, int64(0), h.Child("b").denied)
+		require.Equal(t, int64(1), h.Parent().denied)
+
+		// a denied token is not released twice
+		h.Release(tk)
+		require.Equal(t, int64(1), h.Child("b").used)
+		require.Equal(t, int64(3), h.Parent().used)
+	})
+
+	main.Run("ReleasePassesCongestionToParent", func(t *testing.T) {
+		h := setUp(t)
+
+		tk, allowed := h.Acquire("a")
+		require.True(t, allowed)
+		tk.Congested = true
+		h.Release(tk)
+
+		tk, allowed = h.Acquire("b")
+		require.True(t, allowed)
+		h.Release(tk)
+
+		s := h.Stats()
+		require.Equal(t, int64(1), s.Parent.CongestedCounter)
+		require.Equal(t, int64(1), s.Parent.SuccessfulCounter)
+		require.Equal(t, int64(0), s.Parent.Used)
+		require.Len(t, s.Children, 2)
+		require.Equal(t, int64(1), s.Children["a"].CongestedCounter)
+		require.Equal(t, int64(1), s.Children["b"].SuccessfulCounter)
+	})
+
+	main.Run("ChildrenUseOneLatencyShard", func(t *testing.T) {
+		cfg := Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+
+			DecreaseLatencyPercentile: 0.99,
+			DecreaseLatency:           time.Second,
+		}
+		h, err := NewHierarchy(cfg, cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, h.Close())
+		})
+
+		h.Acquire("a")
+		require.Len(t, h.Child("a").lat.shards, 1)
+		require.Equal(t, min(runtime.GOMAXPROCS(0), defaultLatencyShards), h.Parent().cfg.LatencyShards)
+	})
+
+	main.Run("IdleChildrenEvicted", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		cfg := Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    2,
+
+			Clock: clock,
+		}
+		h, err := NewHierarchy(cfg, cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, h.Close())
+		})
+
+		tk, allowed := h.Acquire("idle")
+		require.True(t, allowed)
+		h.Release(tk)
+		idle := h.Child("idle")
+
+		busy, allowed := h.Acquire("busy")
+		require.True(t, allowed)
+
+		clock.Advance(time.Hour * hierarchyIdlePeriods)
+		h.Acquire("new")
+		require.NotNil(t, h.Child("idle"))
+
+		clock.Advance(time.Hour)
+		h.Acquire("new2")
+		require.Nil(t, h.Child("idle"))
+		require.Equal(t, int32(1), idle.closed)
+
+		// a child with a request in flight is kept, so its token is released to it
+		require.NotNil(t, h.Child("busy"))
+		h.Release(busy)
+		require.Equal(t, int64(0), h.Child("busy").used)
+
+		// a key used again gets a new child
+		tk, allowed = h.Acquire("idle")
+		require.True(t, allowed)
+		require.NotSame(t, idle, h.Child("idle"))
+		h.Release(tk)
+	})
+
+	main.Run("Close", func(t *testing.T) {
+		h := setUp(t)
+
+		h.Acquire("a")
+		require.NoError(t, h.Close())
+
+		tk, allowed := h.Acquire("a")
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonShutdown, tk.DenyReason)
+
+		tk, allowed = h.Acquire("b")
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonShutdown, tk.DenyReason)
+		require.Nil(t, h.Child("b"))
+	})
+}
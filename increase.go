@@ -0,0 +1,103 @@
+package backpressure
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// IncreaseFunc defines how max grows on periods without congestion.
+type IncreaseFunc string
+
+const (
+	// IncreaseMultiplicative grows max by IncreasePercent of it plus one.
+	IncreaseMultiplicative IncreaseFunc = "multiplicative"
+	// IncreaseAdditive grows max by IncreaseStep.
+	IncreaseAdditive IncreaseFunc = "additive"
+	// IncreaseSqrt grows max by IncreaseSqrtFactor times its square root, fast at low limits and slow at high ones.
+	IncreaseSqrt IncreaseFunc = "sqrt"
+	// IncreaseCubic grows max along the CUBIC curve C*(t-K)^3 + Wmax, where Wmax is the max the last decrease
+	// started from, limited by the highest usage, t is the number of DecidePeriods since the decrease
+	// and K = cbrt((Wmax-W)/C) for W the max right after the decrease.
+	// Max grows fast right after a decrease, slows down around Wmax and probes beyond it faster and faster.
+	IncreaseCubic IncreaseFunc = "cubic"
+)
+
+func (bp *Backpreassure) incr(max int64, now time.Time) {
+	var newMax float64
+	switch bp.cfg.IncreaseFunc {
+	case IncreaseAdditive:
+		newMax = float64(max) + float64(bp.cfg.IncreaseStep)
+	case IncreaseSqrt:
+		newMax = float64(max) + math.Ceil(bp.cfg.IncreaseSqrtFactor*math.Sqrt(float64(max)))
+	case IncreaseCubic:
+		wMax := float64(bp.lastDecreaseMax)
+		k := math.Cbrt(math.Max(wMax-float64(bp.lastDecreaseTo), 0) / bp.cfg.IncreaseCubicC)
+		d := float64(now.Sub(bp.lastDecreaseAt))/float64(bp.cfg.DecidePeriod) - k
+		newMax = math.Max(math.Floor(bp.cfg.IncreaseCubicC*d*d*d+wMax), float64(max)+1)
+	default:
+		newMax = math.Floor(float64(max)*(1+bp.cfg.IncreasePercent)) + 1
+	}
+
+	var n int64
+	if newMax >= math.MaxInt64 {
+		n = math.MaxInt64
+	} else {
+		n = int64(newMax)
+	}
+	if n < 0 {
+		n = math.MaxInt64
+	}
+	if n > bp.cfg.MaxMax {
+		n = bp.cfg.MaxMax
+	}
+
+	atomic.StoreInt64(&bp.max, n)
+}
+
+// resetIncrease makes IncreaseCubic grow from max as if it had just been decreased to it.
+// It must be called with decideMux held.
+func (bp *Backpreassure) resetIncrease(max int64) {
+	bp.lastDecreaseMax = max
+	bp.lastDecreaseTo = max
+	bp.lastDecreaseAt = bp.clock.Now()
+}
+
+func validateIncrease(cfg Config) error {
+	switch cfg.IncreaseFunc {
+	case "", IncreaseMultiplicative, IncreaseAdditive, IncreaseSqrt, IncreaseCubic:
+	default:
+		return fmt.Errorf("IncreaseFunc: unknown %q", cfg.IncreaseFunc)
+	}
+
+	if err := validatePercent(cfg.IncreasePercent); err != nil {
+		return fmt.Errorf("IncreasePercent: %s", err)
+	}
+	if cfg.IncreaseFunc == "" || cfg.IncreaseFunc == IncreaseMultiplicative {
+		if cfg.IncreasePercent == 0 {
+			return fmt.Errorf("IncreasePercent: required")
+		}
+	} else if cfg.IncreasePercent == 0 && cfg.RateAdaptive {
+		return fmt.Errorf("IncreasePercent: required by RateAdaptive")
+	}
+	if cfg.IncreasePercent > 0 && cfg.DecreasePercent <= cfg.IncreasePercent {
+		return fmt.Errorf("IncreasePercent: must be less than DecreasePercent")
+	}
+
+	if cfg.IncreaseStep < 0 {
+		return fmt.Errorf("IncreaseStep: negative")
+	}
+	if cfg.IncreaseFunc == IncreaseAdditive && cfg.IncreaseStep == 0 {
+		return fmt.Errorf("IncreaseStep: required")
+	}
+
+	if cfg.IncreaseSqrtFactor < 0 {
+		return fmt.Errorf("IncreaseSqrtFactor: negative")
+	}
+	if cfg.IncreaseCubicC < 0 {
+		return fmt.Errorf("IncreaseCubicC: negative")
+	}
+
+	return nil
+}
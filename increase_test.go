@@ -0,0 +1,168 @@
+package backpressure
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrease(main *testing.T) {
+	setUp := func(t *testing.T, cfg Config) (*Backpreassure, *ManualClock) {
+		clock := NewManualClock(time.Unix(1000, 0))
+
+		cfg.DecidePeriod = time.Hour
+		cfg.DecreasePercent = 0.20
+		cfg.ThresholdPercent = 0.10
+		cfg.MinMax = 1
+		cfg.MaxMax = 1000
+		cfg.Clock = clock
+
+		bp, err := New(cfg)
+		require.NoError(t, err)
+
+		return bp, clock
+	}
+
+	main.Run("Defaults", func(t *testing.T) {
+		bp, _ := setUp(t, Config{IncreasePercent: 0.1})
+		require.Equal(t, IncreaseMultiplicative, bp.cfg.IncreaseFunc)
+		require.Equal(t, float64(0), bp.cfg.IncreaseSqrtFactor)
+		require.Equal(t, float64(0), bp.cfg.IncreaseCubicC)
+
+		bp, _ = setUp(t, Config{IncreaseFunc: IncreaseSqrt})
+		require.Equal(t, float64(1), bp.cfg.IncreaseSqrtFactor)
+
+		bp, _ = setUp(t, Config{IncreaseFunc: IncreaseCubic})
+		require.Equal(t, 0.4, bp.cfg.IncreaseCubicC)
+	})
+
+	main.Run("Multiplicative", func(t *testing.T) {
+		bp, _ := setUp(t, Config{IncreasePercent: 0.1, Max: 100})
+
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(111), bp.max)
+	})
+
+	main.Run("Additive", func(t *testing.T) {
+		bp, _ := setUp(t, Config{IncreaseFunc: IncreaseAdditive, IncreaseStep: 5, Max: 100})
+
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(105), bp.max)
+
+		bp.max = 998
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(1000), bp.max)
+	})
+
+	main.Run("Sqrt", func(t *testing.T) {
+		bp, _ := setUp(t, Config{IncreaseFunc: IncreaseSqrt, Max: 100})
+
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(110), bp.max)
+
+		bp.cfg.IncreaseSqrtFactor = 2
+		bp.max = 16
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(24), bp.max)
+	})
+
+	main.Run("Cubic", func(t *testing.T) {
+		bp, clock := setUp(t, Config{IncreaseFunc: IncreaseCubic, Max: 100})
+
+		bp.congested = 100
+		bp.decide()
+		require.Equal(t, int64(80), bp.max)
+
+		// t is counted in decide periods, concave growth back towards the max before the decrease
+		clock.Advance(time.Hour)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(92), bp.max)
+
+		k := time.Duration(math.Cbrt(50) * float64(time.Hour))
+		clock.Advance(k - time.Hour)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(100), bp.max)
+
+		// at least one step while the curve is flat
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(101), bp.max)
+
+		// convex growth beyond it
+		clock.Advance(time.Hour*10 - k)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(200), bp.max)
+	})
+
+	main.Run("CubicFromUsedMax", func(t *testing.T) {
+		bp, clock := setUp(t, Config{IncreaseFunc: IncreaseCubic, Max: 1000})
+
+		// the decrease starts from the highest usage, so does the growth back
+		bp.usedMax = 10
+		bp.congested = 100
+		bp.decide()
+		require.Equal(t, int64(8), bp.max)
+
+		clock.Advance(time.Hour)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(9), bp.max)
+
+		// K follows the actual cut of 2, cbrt(2/0.4) periods to get back to 10
+		clock.Advance(time.Duration(math.Cbrt(5)*float64(time.Hour)) - time.Hour)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(10), bp.max)
+	})
+
+	main.Run("CubicProportional", func(t *testing.T) {
+		bp, clock := setUp(t, Config{IncreaseFunc: IncreaseCubic, Max: 100, DecreaseProportional: true})
+
+		// 55% congested is half way between ThresholdPercent and 1, a cut of 10%
+		bp.successful = 45
+		bp.congested = 55
+		bp.decide()
+		require.Equal(t, int64(90), bp.max)
+
+		clock.Advance(time.Duration(math.Cbrt(25) * float64(time.Hour)))
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(100), bp.max)
+	})
+
+	main.Run("CubicRestore", func(t *testing.T) {
+		bp, clock := setUp(t, Config{IncreaseFunc: IncreaseCubic, Max: 1000})
+
+		require.NoError(t, bp.Restore(Snapshot{Max: 50}))
+		clock.Advance(time.Hour)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(51), bp.max)
+	})
+
+	main.Run("CubicUnpin", func(t *testing.T) {
+		bp, clock := setUp(t, Config{IncreaseFunc: IncreaseCubic, Max: 1000})
+
+		require.NoError(t, bp.Pin(50))
+		clock.Advance(time.Hour)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(50), bp.max)
+
+		bp.Unpin()
+		clock.Advance(time.Hour)
+		bp.successful = 100
+		bp.decide()
+		require.Equal(t, int64(51), bp.max)
+	})
+}
@@ -0,0 +1,148 @@
+package backpressure
+
+import (
+	"encoding"
+	"log"
+	"math/bits"
+	"math/rand/v2"
+	"sync"
+)
+
+// latencyShards spreads latency recording over several sketches, so concurrent Release calls rarely meet on the same mutex.
+// A shard is picked at random on every record, there is no affinity to Ps,
+// so two concurrent calls still meet on a shard with probability 1/shards.
+type latencyShards struct {
+	shards []latencyShard
+	mask   uint32
+
+	newSketch func() LatencySketch
+	merged    LatencySketch
+	mergeMux  sync.Mutex
+}
+
+// latencyWindows sketches per shard make a sliding window: the oldest one is reset and made current on rotate,
+// so latencies of the last latencyWindows-1 to latencyWindows rotation periods are always available.
+const latencyWindows = 3
+
+type latencyShard struct {
+	mux     sync.Mutex
+	windows [latencyWindows]LatencySketch
+	cur     int
+
+	// keeps neighbouring shards on separate cache lines
+	_ [64]byte
+}
+
+// defaultLatencyShards bounds the default memory of a limiter and the cost of merging shards on many core machines.
+const defaultLatencyShards = 8
+
+func newLatencyShards(newSketch func() LatencySketch, shards int) *latencyShards {
+	n := uint32(1) << bits.Len32(uint32(max(shards, 1))-1)
+
+	ls := &latencyShards{
+		shards:    make([]latencyShard, n),
+		mask:      n - 1,
+		newSketch: newSketch,
+		merged:    newSketch(),
+	}
+	for i := range ls.shards {
+		for j := range ls.shards[i].windows {
+			ls.shards[i].windows[j] = newSketch()
+		}
+	}
+
+	return ls
+}
+
+func (ls *latencyShards) record(dur, n int64) {
+	s := &ls.shards[rand.Uint32()&ls.mask]
+
+	s.mux.Lock()
+	err := s.windows[s.cur].Record(dur, n)
+	s.mux.Unlock()
+
+	if err != nil {
+		log.Printf("[ERROR] backpressure: latency sketch: record value: %s", err)
+	}
+}
+
+// merge folds all windows of all shards into a sketch reused between calls and passes it to fn.
+// The sketch must not be retained after fn returns.
+func (ls *latencyShards) merge(fn func(ms LatencySketch)) {
+	ls.mergeMux.Lock()
+	defer ls.mergeMux.Unlock()
+
+	ls.merged.Reset()
+	for i := range ls.shards {
+		s := &ls.shards[i]
+
+		s.mux.Lock()
+		for _, w := range s.windows {
+			if err := ls.merged.Merge(w); err != nil {
+				log.Printf("[ERROR] backpressure: latency sketch: merge: %s", err)
+			}
+		}
+		s.mux.Unlock()
+	}
+
+	fn(ls.merged)
+}
+
+// rotate drops the oldest window of every shard and records into it from now on.
+func (ls *latencyShards) rotate() {
+	for i := range ls.shards {
+		s := &ls.shards[i]
+
+		s.mux.Lock()
+		s.cur = (s.cur + 1) % latencyWindows
+		s.windows[s.cur].Reset()
+		s.mux.Unlock()
+	}
+}
+
+func (ls *latencyShards) reset() {
+	for i := range ls.shards {
+		s := &ls.shards[i]
+
+		s.mux.Lock()
+		for _, w := range s.windows {
+			w.Reset()
+		}
+		s.mux.Unlock()
+	}
+}
+
+// restore replaces recorded latencies with the ones marshaled by the sketch.
+// They are decoded into a new sketch first, so on error recorded latencies are kept.
+// Sketches which do not implement encoding.BinaryUnmarshaler are left as is.
+func (ls *latencyShards) restore(data []byte) error {
+	sk := ls.newSketch()
+	u, ok := sk.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return nil
+	}
+	if err := u.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	ls.reset()
+
+	s := &ls.shards[0]
+	s.mux.Lock()
+	s.windows[s.cur] = sk
+	s.mux.Unlock()
+
+	return nil
+}
+
+// quantiles returns merged latencies at the given quantiles.
+func (ls *latencyShards) quantiles(qs ...float64) []int64 {
+	vs := make([]int64, len(qs))
+	ls.merge(func(ms LatencySketch) {
+		for i, q := range qs {
+			vs[i] = ms.Quantile(q)
+		}
+	})
+
+	return vs
+}
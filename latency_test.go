@@ -0,0 +1,92 @@
+package backpressure
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyShards(main *testing.T) {
+	newSketch := func() LatencySketch {
+		return NewHDRSketch()
+	}
+
+	main.Run("PowerOfTwo", func(t *testing.T) {
+		ls := newLatencyShards(newSketch, 5)
+		require.Len(t, ls.shards, 8)
+		require.Equal(t, uint32(7), ls.mask)
+
+		ls = newLatencyShards(newSketch, 1)
+		require.Len(t, ls.shards, 1)
+		require.Equal(t, uint32(0), ls.mask)
+	})
+
+	main.Run("MergeAllShards", func(t *testing.T) {
+		ls := newLatencyShards(newSketch, 8)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					ls.record(time.Millisecond.Nanoseconds(), 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		ls.merge(func(ms LatencySketch) {
+			require.Equal(t, int64(8000), ms.(*HDRSketch).h.TotalCount())
+			require.InEpsilon(t, time.Millisecond.Nanoseconds(), ms.Quantile(0.5), 0.1)
+		})
+	})
+
+	main.Run("Reset", func(t *testing.T) {
+		ls := newLatencyShards(newSketch, 8)
+		ls.record(time.Millisecond.Nanoseconds(), 1)
+		ls.reset()
+
+		ls.merge(func(ms LatencySketch) {
+			require.Equal(t, int64(0), ms.(*HDRSketch).h.TotalCount())
+		})
+	})
+
+	main.Run("Rotate", func(t *testing.T) {
+		ls := newLatencyShards(newSketch, 8)
+		ls.record(time.Millisecond.Nanoseconds(), 1)
+
+		count := func() int64 {
+			var n int64
+			ls.merge(func(ms LatencySketch) {
+				n = ms.(*HDRSketch).h.TotalCount()
+			})
+			return n
+		}
+
+		for i := 1; i < latencyWindows; i++ {
+			ls.rotate()
+			ls.record(time.Millisecond.Nanoseconds(), 1)
+			require.Equal(t, int64(i+1), count())
+		}
+
+		// the oldest window is dropped, the newer ones are kept
+		ls.rotate()
+		require.Equal(t, int64(latencyWindows-1), count())
+	})
+
+	main.Run("DDSketch", func(t *testing.T) {
+		ls := newLatencyShards(func() LatencySketch {
+			return NewDDSketch(0.01)
+		}, 8)
+		for i := 1; i <= 100; i++ {
+			ls.record(int64(i)*time.Millisecond.Nanoseconds(), 1)
+		}
+
+		ls.merge(func(ms LatencySketch) {
+			require.InEpsilon(t, 50*time.Millisecond.Nanoseconds(), ms.Quantile(0.5), 0.02)
+		})
+	})
+}
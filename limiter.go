@@ -0,0 +1,12 @@
+package backpressure
+
+// Limiter decides whether a request may be sent to a protected resource.
+// Every allowed token must be passed back to Release once the request is done, or to Cancel if it was not sent.
+type Limiter interface {
+	Acquire() (Token, bool)
+	Release(t Token)
+	Cancel(t Token)
+	Stats() AIMDStats
+}
+
+var _ Limiter = (*Backpreassure)(nil)
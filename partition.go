@@ -0,0 +1,130 @@
+package backpressure
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+type PartitionStats struct {
+	// Guaranteed is the part of max reserved for the partition at the moment.
+	Guaranteed int64
+	Used       int64
+
+	// Counters are cumulative and include the period in progress.
+	SuccessfulCounter int64
+	CongestedCounter  int64
+	DeniedCounter     int64
+}
+
+type partition struct {
+	name    string
+	percent float64
+
+	used       int64
+	successful int64
+	congested  int64
+	denied     int64
+}
+
+func newPartitions(percents map[string]float64) ([]*partition, map[string]*partition) {
+	if len(percents) == 0 {
+		return nil, nil
+	}
+
+	ps := make([]*partition, 0, len(percents))
+	byName := make(map[string]*partition, len(percents))
+	for name, percent := range percents {
+		p := &partition{
+			name:    name,
+			percent: percent,
+		}
+		ps = append(ps, p)
+		byName[name] = p
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].name < ps[j].name
+	})
+
+	return ps, byName
+}
+
+func (p *partition) guaranteed(maxCap int64) int64 {
+	return int64(math.Floor(float64(maxCap) * p.percent))
+}
+
+func (p *partition) release(congested bool) {
+	atomic.AddInt64(&p.used, -1)
+	if !congested {
+		atomic.AddInt64(&p.successful, 1)
+	} else {
+		atomic.AddInt64(&p.congested, 1)
+	}
+}
+
+// AcquirePartition is like Acquire but takes capacity from a partition configured by Config.Partitions.
+// A partition may always use its guaranteed part of max and bursts into capacity
+// not guaranteed to other partitions or guaranteed but unused by them.
+// Requests of unknown partitions and requests acquired by Acquire have no guarantee and may only burst.
+func (bp *Backpreassure) AcquirePartition(name string) (Token, bool) {
+	p := bp.partitionByName[name]
+
+	var t Token
+	allowed := bp.acquire(acquireReq{
+		priority:  PriorityDefault,
+		partition: p,
+	}, &t)
+	if !allowed {
+		if p != nil {
+			atomic.AddInt64(&p.denied, 1)
+		}
+		bp.deny(t)
+	}
+
+	return t, allowed
+}
+
+// takePartition takes one slot of the partition if it is within its guarantee
+// or there is capacity left once unused guarantees of other partitions are reserved. used includes the slot.
+func (bp *Backpreassure) takePartition(p *partition, used, maxCap int64) bool {
+	if p != nil {
+		if atomic.AddInt64(&p.used, 1) <= p.guaranteed(maxCap) {
+			return true
+		}
+	}
+
+	var reserved int64
+	for _, p2 := range bp.partitions {
+		if p2 == p {
+			continue
+		}
+		reserved += max(p2.guaranteed(maxCap)-atomic.LoadInt64(&p2.used), 0)
+	}
+	if used <= maxCap-reserved {
+		return true
+	}
+
+	if p != nil {
+		atomic.AddInt64(&p.used, -1)
+	}
+	return false
+}
+
+func (bp *Backpreassure) partitionStats(maxCap int64) map[string]PartitionStats {
+	if len(bp.partitions) == 0 {
+		return nil
+	}
+
+	ps := make(map[string]PartitionStats, len(bp.partitions))
+	for _, p := range bp.partitions {
+		ps[p.name] = PartitionStats{
+			Guaranteed:        p.guaranteed(maxCap),
+			Used:              atomic.LoadInt64(&p.used),
+			SuccessfulCounter: atomic.LoadInt64(&p.successful),
+			CongestedCounter:  atomic.LoadInt64(&p.congested),
+			DeniedCounter:     atomic.LoadInt64(&p.denied),
+		}
+	}
+
+	return ps
+}
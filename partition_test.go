@@ -0,0 +1,156 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition(main *testing.T) {
+	setUp := func(t *testing.T) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    10,
+
+			Partitions: map[string]float64{
+				"checkout": 0.5,
+				"search":   0.2,
+			},
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	acquire := func(t *testing.T, bp *Backpreassure, name string, n int) ([]Token, DenyReason) {
+		var ts []Token
+		var reason DenyReason
+		for i := 0; i < n; i++ {
+			tk, allowed := bp.AcquirePartition(name)
+			if !allowed {
+				reason = tk.DenyReason
+				continue
+			}
+
+			ts = append(ts, tk)
+		}
+
+		return ts, reason
+	}
+
+	main.Run("Burst", func(t *testing.T) {
+		bp := setUp(t)
+
+		// unused guarantee of search is kept
+		ts, reason := acquire(t, bp, "checkout", 10)
+		require.Len(t, ts, 8)
+		require.Equal(t, DenyReasonPartition, reason)
+		require.Equal(t, "checkout", ts[0].Partition)
+
+		ts, reason = acquire(t, bp, "search", 10)
+		require.Len(t, ts, 2)
+		require.Equal(t, DenyReasonOverLimit, reason)
+	})
+
+	main.Run("NoGuarantee", func(t *testing.T) {
+		bp := setUp(t)
+
+		ts, reason := acquire(t, bp, "unknown", 10)
+		require.Len(t, ts, 3)
+		require.Equal(t, DenyReasonPartition, reason)
+		require.Empty(t, ts[0].Partition)
+
+		_, allowed := bp.Acquire()
+		require.False(t, allowed)
+
+		ts, _ = acquire(t, bp, "checkout", 10)
+		require.Len(t, ts, 5)
+		ts, _ = acquire(t, bp, "search", 10)
+		require.Len(t, ts, 2)
+	})
+
+	main.Run("GuaranteeFollowsMax", func(t *testing.T) {
+		bp := setUp(t)
+		bp.max = 20
+
+		ts, _ := acquire(t, bp, "checkout", 20)
+		require.Len(t, ts, 16)
+		ts, _ = acquire(t, bp, "search", 20)
+		require.Len(t, ts, 4)
+	})
+
+	main.Run("Stats", func(t *testing.T) {
+		bp := setUp(t)
+
+		ts, _ := acquire(t, bp, "checkout", 9)
+		ts[0].Congested = true
+		bp.Release(ts[0])
+		bp.Release(ts[1])
+		sts, _ := acquire(t, bp, "search", 1)
+		bp.Cancel(sts[0])
+
+		s := bp.Stats()
+		require.Equal(t, map[string]PartitionStats{
+			"checkout": {
+				Guaranteed:        5,
+				Used:              6,
+				SuccessfulCounter: 1,
+				CongestedCounter:  1,
+				DeniedCounter:     1,
+			},
+			"search": {
+				Guaranteed: 2,
+			},
+		}, s.Partitions)
+		require.Equal(t, map[DenyReason]int64{DenyReasonPartition: 1}, s.DeniedReasons)
+
+		bp.ResetStats()
+		require.Equal(t, PartitionStats{Guaranteed: 5, Used: 6}, bp.Stats().Partitions["checkout"])
+	})
+
+	main.Run("TenantRolledBack", func(t *testing.T) {
+		bp := setUp(t)
+
+		// partitions keep 7 of 10 for themselves, the tenant slots of denied requests are given back
+		var tks []Token
+		for i := 0; i < 5; i++ {
+			tk, allowed := bp.AcquireTenant("x", 1)
+			if !allowed {
+				require.Equal(t, DenyReasonPartition, tk.DenyReason)
+				continue
+			}
+			tks = append(tks, tk)
+		}
+		require.Len(t, tks, 3)
+		require.Equal(t, int64(3), bp.Stats().Tenants[0].Used)
+		require.Equal(t, int64(3), bp.used)
+
+		for _, tk := range tks {
+			bp.Release(tk)
+		}
+		require.Equal(t, int64(0), bp.Stats().Tenants[0].Used)
+		require.Equal(t, int64(0), bp.used)
+	})
+
+	main.Run("NotConfigured", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+			Max:             2,
+		})
+		require.NoError(t, err)
+
+		tk, allowed := bp.AcquirePartition("checkout")
+		require.True(t, allowed)
+		require.Empty(t, tk.Partition)
+		require.Nil(t, bp.Stats().Partitions)
+	})
+}
@@ -0,0 +1,151 @@
+package backpressure
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// Priority is a class of requests. Lower priority requests are denied first as max shrinks,
+// see Config.DefaultPriorityPercent and Config.SheddablePriorityPercent.
+type Priority uint8
+
+const (
+	// PriorityDefault is used by Acquire and AcquireWait.
+	PriorityDefault Priority = iota
+	// PriorityCritical may always use the whole max.
+	PriorityCritical
+	// PrioritySheddable is denied first.
+	PrioritySheddable
+
+	priorityCount
+)
+
+var priorityNames = [priorityCount]string{
+	PriorityDefault:   "default",
+	PriorityCritical:  "critical",
+	PrioritySheddable: "sheddable",
+}
+
+func (p Priority) String() string {
+	if p >= priorityCount {
+		return fmt.Sprintf("Priority(%d)", p)
+	}
+
+	return priorityNames[p]
+}
+
+func (p Priority) MarshalText() ([]byte, error) {
+	if p >= priorityCount {
+		return nil, fmt.Errorf("unknown priority %d", p)
+	}
+
+	return []byte(priorityNames[p]), nil
+}
+
+func (p *Priority) UnmarshalText(text []byte) error {
+	for i, name := range priorityNames {
+		if name == string(text) {
+			*p = Priority(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown priority %q", text)
+}
+
+type PriorityStats struct {
+	Used int64
+
+	// Counters are cumulative and include the period in progress.
+	SuccessfulCounter int64
+	CongestedCounter  int64
+	DeniedCounter     int64
+}
+
+type priorityCounters struct {
+	used       int64
+	successful int64
+	congested  int64
+	denied     int64
+}
+
+// AcquirePriority is like Acquire but takes the priority of the request into account.
+func (bp *Backpreassure) AcquirePriority(p Priority) (Token, bool) {
+	if p >= priorityCount {
+		p = PriorityDefault
+	}
+
+	var t Token
+	allowed := bp.acquire(acquireReq{priority: p}, &t)
+	if !allowed {
+		bp.deny(t)
+	}
+
+	return t, allowed
+}
+
+// priorityLimit returns how much of max requests of the priority may use.
+func (bp *Backpreassure) priorityLimit(p Priority, maxCap int64) int64 {
+	var percent float64
+	switch p {
+	case PriorityDefault:
+		percent = bp.cfg.DefaultPriorityPercent
+	case PrioritySheddable:
+		percent = bp.cfg.SheddablePriorityPercent
+	default:
+		return maxCap
+	}
+
+	return max(int64(math.Ceil(float64(maxCap)*percent)), 1)
+}
+
+// priorityCounters returns the counters of the priority, nil for PriorityDefault which is not counted
+// to keep plain Acquire and Release cheap.
+func (bp *Backpreassure) priorityCounters(p Priority) *priorityCounters {
+	if p == PriorityDefault || p >= priorityCount {
+		return nil
+	}
+
+	return &bp.prio[p]
+}
+
+// priorityStats breaks the totals of s down by priority. PriorityDefault gets what other priorities do not account for.
+func (bp *Backpreassure) priorityStats(s AIMDStats) map[Priority]PriorityStats {
+	def := PriorityStats{
+		Used:              s.Used,
+		SuccessfulCounter: s.SuccessfulCounter,
+		CongestedCounter:  s.CongestedCounter,
+		DeniedCounter:     s.DeniedCounter,
+	}
+
+	ps := make(map[Priority]PriorityStats, priorityCount)
+	for i := range bp.prio {
+		if Priority(i) == PriorityDefault {
+			continue
+		}
+
+		c := &bp.prio[i]
+		pst := PriorityStats{
+			Used:              atomic.LoadInt64(&c.used),
+			SuccessfulCounter: atomic.LoadInt64(&c.successful),
+			CongestedCounter:  atomic.LoadInt64(&c.congested),
+			DeniedCounter:     atomic.LoadInt64(&c.denied),
+		}
+		ps[Priority(i)] = pst
+
+		def.Used -= pst.Used
+		def.SuccessfulCounter -= pst.SuccessfulCounter
+		def.CongestedCounter -= pst.CongestedCounter
+		def.DeniedCounter -= pst.DeniedCounter
+	}
+
+	// the totals and the counters are not read at once, a request in flight may be seen by one only
+	def.Used = max(def.Used, 0)
+	def.SuccessfulCounter = max(def.SuccessfulCounter, 0)
+	def.CongestedCounter = max(def.CongestedCounter, 0)
+	def.DeniedCounter = max(def.DeniedCounter, 0)
+	ps[PriorityDefault] = def
+
+	return ps
+}
@@ -0,0 +1,186 @@
+package backpressure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriority(main *testing.T) {
+	setUp := func(t *testing.T) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    10,
+
+			DefaultPriorityPercent:   0.8,
+			SheddablePriorityPercent: 0.5,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	acquire := func(t *testing.T, bp *Backpreassure, p Priority, n int) []Token {
+		var ts []Token
+		for i := 0; i < n; i++ {
+			tk, allowed := bp.AcquirePriority(p)
+			if !allowed {
+				require.Contains(t, []DenyReason{DenyReasonPriority, DenyReasonOverLimit}, tk.DenyReason)
+				require.Equal(t, p, tk.Priority)
+				continue
+			}
+
+			require.Equal(t, p, tk.Priority)
+			ts = append(ts, tk)
+		}
+
+		return ts
+	}
+
+	main.Run("Defaults", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:           time.Hour,
+			DecreasePercent:        0.20,
+			IncreasePercent:        0.10,
+			DefaultPriorityPercent: 0.7,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 0.7, bp.cfg.SheddablePriorityPercent)
+
+		bp, err = New(Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, float64(1), bp.cfg.DefaultPriorityPercent)
+		require.Equal(t, float64(1), bp.cfg.SheddablePriorityPercent)
+	})
+
+	main.Run("ReservedCapacity", func(t *testing.T) {
+		bp := setUp(t)
+
+		require.Len(t, acquire(t, bp, PrioritySheddable, 10), 5)
+		require.Len(t, acquire(t, bp, PriorityDefault, 10), 3)
+		require.Len(t, acquire(t, bp, PriorityCritical, 10), 2)
+
+		tk, allowed := bp.AcquirePriority(PriorityCritical)
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonOverLimit, tk.DenyReason)
+	})
+
+	main.Run("ShrinkingMaxShedsLowPriorityFirst", func(t *testing.T) {
+		bp := setUp(t)
+		bp.max = 2
+
+		require.Len(t, acquire(t, bp, PrioritySheddable, 10), 1)
+		require.Len(t, acquire(t, bp, PriorityDefault, 10), 1)
+		require.Len(t, acquire(t, bp, PriorityCritical, 10), 0)
+
+		bp.used = 0
+		bp.max = 1
+		require.Len(t, acquire(t, bp, PriorityCritical, 10), 1)
+	})
+
+	main.Run("Stats", func(t *testing.T) {
+		bp := setUp(t)
+
+		ts := acquire(t, bp, PrioritySheddable, 6)
+		ts[0].Congested = true
+		bp.Release(ts[0])
+		bp.Release(ts[1])
+
+		cts := acquire(t, bp, PriorityCritical, 1)
+		_, allowed := bp.Acquire()
+		require.True(t, allowed)
+
+		s := bp.Stats()
+		require.Equal(t, PriorityStats{
+			Used:              3,
+			SuccessfulCounter: 1,
+			CongestedCounter:  1,
+			DeniedCounter:     1,
+		}, s.Priorities[PrioritySheddable])
+		require.Equal(t, PriorityStats{Used: 1}, s.Priorities[PriorityCritical])
+		require.Equal(t, PriorityStats{Used: 1}, s.Priorities[PriorityDefault])
+		require.Equal(t, map[DenyReason]int64{DenyReasonPriority: 1}, s.DeniedReasons)
+
+		bp.Release(cts[0])
+		require.Equal(t, int64(0), bp.Stats().Priorities[PriorityCritical].Used)
+		require.Equal(t, int64(1), bp.Stats().Priorities[PriorityCritical].SuccessfulCounter)
+	})
+
+	main.Run("QueuedWaitersKeepCriticalReserve", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    10,
+
+			DefaultPriorityPercent: 0.5,
+			QueueSize:              10,
+		})
+		require.NoError(t, err)
+
+		cts := acquire(t, bp, PriorityCritical, 10)
+		require.Len(t, cts, 10)
+
+		resCh := make(chan bool, 5)
+		for i := 0; i < 5; i++ {
+			go func() {
+				_, allowed := bp.AcquireWait(context.Background())
+				resCh <- allowed
+			}()
+		}
+		require.Eventually(t, func() bool {
+			return bp.q.len() == 5
+		}, time.Second, time.Millisecond)
+
+		// the used capacity is above the default priority limit of 5, the rest is reserved for critical requests
+		for _, tk := range cts[:5] {
+			bp.Release(tk)
+		}
+		require.Equal(t, int64(5), bp.q.len())
+		require.Equal(t, int64(5), bp.used)
+		require.Len(t, acquire(t, bp, PriorityCritical, 1), 1)
+
+		for _, tk := range cts[5:] {
+			bp.Release(tk)
+		}
+		require.Equal(t, int64(1), bp.q.len())
+		require.Equal(t, int64(5), bp.used)
+		for i := 0; i < 4; i++ {
+			require.True(t, <-resCh)
+		}
+		require.Len(t, acquire(t, bp, PriorityCritical, 10), 5)
+
+		require.NoError(t, bp.Close())
+		require.False(t, <-resCh)
+	})
+
+	main.Run("Text", func(t *testing.T) {
+		for p := PriorityDefault; p < priorityCount; p++ {
+			text, err := p.MarshalText()
+			require.NoError(t, err)
+
+			var p2 Priority
+			require.NoError(t, p2.UnmarshalText(text))
+			require.Equal(t, p, p2)
+		}
+
+		var p Priority
+		require.EqualError(t, p.UnmarshalText([]byte("foo")), `unknown priority "foo"`)
+	})
+}
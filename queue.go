@@ -0,0 +1,223 @@
+package backpressure
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// AcquireWait is like Acquire but waits for capacity if max, the priority reserve or the partition reserves are reached
+// and QueueSize is configured.
+// Waiters are served by CoDel: while the queue drains in time they are served in FIFO order.
+// Once the minimum time spent in the queue over a QueueInterval goes above QueueTarget, the queue is considered standing:
+// waiters queued for longer than QueueTarget are dropped and the rest are served in LIFO order, so the freshest requests go first.
+// Without QueueSize it behaves exactly like Acquire.
+func (bp *Backpreassure) AcquireWait(ctx context.Context) (Token, bool) {
+	var t Token
+	allowed := bp.acquire(acquireReq{priority: PriorityDefault}, &t)
+	if allowed {
+		return t, true
+	}
+	if bp.q == nil || !waitsForCapacity(t.DenyReason) {
+		bp.deny(t)
+		return t, false
+	}
+
+	w := &waiter{
+		ch:         make(chan Token, 1),
+		enqueuedAt: bp.clock.Now().UnixNano(),
+	}
+	if !bp.q.push(w) {
+		t.DenyReason = DenyReasonQueueFull
+		bp.deny(t)
+		return t, false
+	}
+
+	// capacity might have been released while the waiter was being queued
+	bp.dispatch()
+
+	select {
+	case t := <-w.ch:
+		return t, !t.Denied
+	case <-ctx.Done():
+		if bp.q.remove(w) {
+			t := deniedToken(atomic.LoadInt64(&bp.max), atomic.LoadInt64(&bp.used), DenyReasonContextDone)
+			bp.deny(t)
+			return t, false
+		}
+
+		// the waiter has already been served
+		t := <-w.ch
+		return t, !t.Denied
+	}
+}
+
+// dispatch hands free capacity to waiters. Waiters go through the same limits as Acquire:
+// it stops once max, the priority or the partition limit is reached and denies waiters other limits reject,
+// for example while the breaker is open.
+func (bp *Backpreassure) dispatch() {
+	for bp.q.len() > 0 {
+		var t Token
+		allowed := bp.checkLimits(acquireReq{priority: PriorityDefault}, &t)
+		if !allowed && waitsForCapacity(t.DenyReason) {
+			return
+		}
+
+		w, dropped := bp.q.pop(bp.clock.Now().UnixNano())
+		for _, d := range dropped {
+			dt := deniedToken(t.Max, t.Used-1, DenyReasonQueueDrop)
+			bp.deny(dt)
+			d.ch <- dt
+		}
+		if w == nil {
+			if allowed {
+				bp.giveBack(t)
+			}
+			return
+		}
+
+		if !allowed {
+			bp.deny(t)
+		}
+		w.ch <- t
+	}
+}
+
+// waitsForCapacity tells whether a waiter denied for the reason should stay queued until capacity is released.
+func waitsForCapacity(reason DenyReason) bool {
+	return reason == DenyReasonOverLimit || reason == DenyReasonPriority || reason == DenyReasonPartition
+}
+
+type waiter struct {
+	// ch receives a single token, either acquired or denied
+	ch         chan Token
+	enqueuedAt int64
+}
+
+// codelQueue holds waiters and tracks the minimum sojourn time of served waiters over an interval.
+type codelQueue struct {
+	mux     sync.Mutex
+	waiters []*waiter
+	n       int64
+	closed  bool
+
+	size     int
+	target   int64
+	interval int64
+
+	intervalStartAt int64
+	minSojourn      int64
+	overloaded      bool
+}
+
+func newCodelQueue(size int, target, interval int64) *codelQueue {
+	return &codelQueue{
+		size:     size,
+		target:   target,
+		interval: interval,
+	}
+}
+
+func (q *codelQueue) len() int64 {
+	return atomic.LoadInt64(&q.n)
+}
+
+func (q *codelQueue) isOverloaded() bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.overloaded
+}
+
+func (q *codelQueue) push(w *waiter) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.closed || len(q.waiters) >= q.size {
+		return false
+	}
+
+	if len(q.waiters) == 0 {
+		// the queue has been empty within the interval, there is no standing queue
+		q.minSojourn = 0
+	}
+	q.waiters = append(q.waiters, w)
+	atomic.StoreInt64(&q.n, int64(len(q.waiters)))
+
+	return true
+}
+
+func (q *codelQueue) remove(w *waiter) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	for i, w2 := range q.waiters {
+		if w2 == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			atomic.StoreInt64(&q.n, int64(len(q.waiters)))
+			return true
+		}
+	}
+
+	return false
+}
+
+// pop returns the waiter to serve next and waiters dropped because they have been queued for too long.
+func (q *codelQueue) pop(now int64) (*waiter, []*waiter) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	defer func() {
+		atomic.StoreInt64(&q.n, int64(len(q.waiters)))
+	}()
+
+	if now-q.intervalStartAt >= q.interval {
+		q.overloaded = q.minSojourn > q.target
+		q.intervalStartAt = now
+		q.minSojourn = math.MaxInt64
+	}
+
+	if len(q.waiters) == 0 {
+		q.minSojourn = 0
+		return nil, nil
+	}
+
+	var dropped []*waiter
+	var w *waiter
+	if q.overloaded {
+		for len(q.waiters) > 1 && now-q.waiters[0].enqueuedAt > q.target {
+			dropped = append(dropped, q.waiters[0])
+			q.waiters[0] = nil
+			q.waiters = q.waiters[1:]
+		}
+
+		w = q.waiters[len(q.waiters)-1]
+		q.waiters[len(q.waiters)-1] = nil
+		q.waiters = q.waiters[:len(q.waiters)-1]
+	} else {
+		w = q.waiters[0]
+		q.waiters[0] = nil
+		q.waiters = q.waiters[1:]
+	}
+
+	q.minSojourn = min(q.minSojourn, now-w.enqueuedAt)
+	if len(q.waiters) == 0 {
+		// the queue drained, there is no standing queue
+		q.minSojourn = 0
+	}
+
+	return w, dropped
+}
+
+// close refuses new waiters and returns the queued ones.
+func (q *codelQueue) close() []*waiter {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.closed = true
+	ws := q.waiters
+	q.waiters = nil
+	atomic.StoreInt64(&q.n, 0)
+
+	return ws
+}
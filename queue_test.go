@@ -0,0 +1,342 @@
+package backpressure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireWait(main *testing.T) {
+	setUp := func(t *testing.T, queueSize int) (*Backpreassure, *ManualClock) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    1,
+
+			QueueSize: queueSize,
+			Clock:     clock,
+		})
+		require.NoError(t, err)
+
+		return bp, clock
+	}
+
+	type result struct {
+		t       Token
+		allowed bool
+	}
+
+	wait := func(ctx context.Context, bp *Backpreassure, queued int64) <-chan result {
+		resCh := make(chan result, 1)
+		go func() {
+			t, allowed := bp.AcquireWait(ctx)
+			resCh <- result{t, allowed}
+		}()
+
+		require.Eventually(main, func() bool {
+			return bp.q.len() == queued
+		}, time.Second, time.Millisecond)
+
+		return resCh
+	}
+
+	main.Run("Disabled", func(t *testing.T) {
+		bp, _ := setUp(t, 0)
+
+		_, allowed := bp.AcquireWait(context.Background())
+		require.True(t, allowed)
+
+		tk, allowed := bp.AcquireWait(context.Background())
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonOverLimit, tk.DenyReason)
+	})
+
+	main.Run("FIFO", func(t *testing.T) {
+		bp, clock := setUp(t, 10)
+
+		t1, allowed := bp.AcquireWait(context.Background())
+		require.True(t, allowed)
+
+		res1 := wait(context.Background(), bp, 1)
+		clock.Advance(time.Millisecond)
+		res2 := wait(context.Background(), bp, 2)
+		require.Equal(t, int64(2), bp.Stats().Queued)
+
+		bp.Release(t1)
+		r1 := <-res1
+		require.True(t, r1.allowed)
+		require.Equal(t, int64(1), bp.used)
+
+		bp.Release(r1.t)
+		r2 := <-res2
+		require.True(t, r2.allowed)
+
+		bp.Release(r2.t)
+		require.Equal(t, int64(0), bp.used)
+		require.Equal(t, int64(0), bp.Stats().DeniedCounter)
+	})
+
+	main.Run("WaitersGoThroughPartitions", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    10,
+
+			QueueSize:  10,
+			Partitions: map[string]float64{"checkout": 0.5},
+		})
+		require.NoError(t, err)
+
+		var cts, ts []Token
+		for i := 0; i < 5; i++ {
+			tk, allowed := bp.AcquirePartition("checkout")
+			require.True(t, allowed)
+			cts = append(cts, tk)
+
+			tk, allowed = bp.AcquireWait(context.Background())
+			require.True(t, allowed)
+			ts = append(ts, tk)
+		}
+
+		res := wait(context.Background(), bp, 1)
+
+		// the released slot is guaranteed to checkout
+		bp.Release(cts[0])
+		require.Equal(t, int64(1), bp.q.len())
+		require.Equal(t, int64(9), bp.used)
+
+		bp.Release(ts[0])
+		r := <-res
+		require.True(t, r.allowed)
+		require.Equal(t, int64(9), bp.used)
+	})
+
+	main.Run("WaitsForPriorityReserve", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    4,
+
+			QueueSize:              10,
+			DefaultPriorityPercent: 0.5,
+		})
+		require.NoError(t, err)
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+		_, allowed = bp.Acquire()
+		require.True(t, allowed)
+
+		// the rest of max is reserved for critical requests, the caller waits instead of being denied
+		res := wait(context.Background(), bp, 1)
+
+		bp.Release(t1)
+		r := <-res
+		require.True(t, r.allowed)
+		require.Equal(t, int64(2), bp.used)
+	})
+
+	main.Run("WaitsForPartitionReserve", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    10,
+
+			QueueSize:  10,
+			Partitions: map[string]float64{"checkout": 0.5},
+		})
+		require.NoError(t, err)
+
+		var ts []Token
+		for i := 0; i < 5; i++ {
+			tk, allowed := bp.AcquireWait(context.Background())
+			require.True(t, allowed)
+			ts = append(ts, tk)
+		}
+
+		// the rest of max is reserved for checkout
+		res := wait(context.Background(), bp, 1)
+
+		bp.Release(ts[0])
+		r := <-res
+		require.True(t, r.allowed)
+		require.Equal(t, int64(5), bp.used)
+	})
+
+	main.Run("QueueFull", func(t *testing.T) {
+		bp, _ := setUp(t, 1)
+
+		t1, allowed := bp.AcquireWait(context.Background())
+		require.True(t, allowed)
+
+		res1 := wait(context.Background(), bp, 1)
+
+		tk, allowed := bp.AcquireWait(context.Background())
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonQueueFull, tk.DenyReason)
+
+		bp.Release(t1)
+		require.True(t, (<-res1).allowed)
+	})
+
+	main.Run("ContextDone", func(t *testing.T) {
+		bp, _ := setUp(t, 10)
+
+		t1, allowed := bp.AcquireWait(context.Background())
+		require.True(t, allowed)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		res1 := wait(ctx, bp, 1)
+		cancel()
+
+		r1 := <-res1
+		require.False(t, r1.allowed)
+		require.Equal(t, DenyReasonContextDone, r1.t.DenyReason)
+		require.Equal(t, int64(0), bp.q.len())
+
+		bp.Release(t1)
+		require.Equal(t, int64(0), bp.used)
+		require.Equal(t, map[DenyReason]int64{DenyReasonContextDone: 1}, bp.Stats().DeniedReasons)
+	})
+
+	main.Run("DecideGrowsMax", func(t *testing.T) {
+		bp, _ := setUp(t, 10)
+
+		t1, allowed := bp.AcquireWait(context.Background())
+		require.True(t, allowed)
+
+		res1 := wait(context.Background(), bp, 1)
+
+		bp.successful = 100
+		bp.decide()
+
+		r1 := <-res1
+		require.True(t, r1.allowed)
+		require.Equal(t, int64(2), bp.used)
+
+		bp.Release(t1)
+		bp.Release(r1.t)
+	})
+
+	main.Run("Close", func(t *testing.T) {
+		bp, _ := setUp(t, 10)
+
+		_, allowed := bp.AcquireWait(context.Background())
+		require.True(t, allowed)
+
+		res1 := wait(context.Background(), bp, 1)
+		require.NoError(t, bp.Close())
+
+		r1 := <-res1
+		require.False(t, r1.allowed)
+		require.Equal(t, DenyReasonShutdown, r1.t.DenyReason)
+
+		tk, allowed := bp.AcquireWait(context.Background())
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonShutdown, tk.DenyReason)
+	})
+}
+
+func TestCodelQueue(main *testing.T) {
+	const ms = int64(time.Millisecond)
+
+	push := func(t *testing.T, q *codelQueue, enqueuedAt ...int64) []*waiter {
+		ws := make([]*waiter, len(enqueuedAt))
+		for i, at := range enqueuedAt {
+			ws[i] = &waiter{enqueuedAt: at}
+			require.True(t, q.push(ws[i]))
+		}
+
+		return ws
+	}
+
+	main.Run("FIFOWhileDraining", func(t *testing.T) {
+		q := newCodelQueue(10, 5*ms, 100*ms)
+		ws := push(t, q, 0, 1*ms, 2*ms)
+
+		w, dropped := q.pop(3 * ms)
+		require.Same(t, ws[0], w)
+		require.Empty(t, dropped)
+
+		w, _ = q.pop(200 * ms)
+		require.Same(t, ws[1], w)
+		require.False(t, q.overloaded)
+	})
+
+	main.Run("StandingQueue", func(t *testing.T) {
+		q := newCodelQueue(10, 5*ms, 100*ms)
+		ws := push(t, q, 0, 1*ms, 2*ms, 3*ms)
+
+		// the queue was empty within the first interval
+		w, _ := q.pop(100 * ms)
+		require.Same(t, ws[0], w)
+		require.False(t, q.overloaded)
+
+		ws = append(ws, push(t, q, 150*ms, 198*ms)...)
+
+		// the queue has not drained within the second interval and min sojourn was 100ms, which is above the 5ms target
+		w, dropped := q.pop(200 * ms)
+		require.True(t, q.overloaded)
+		require.Same(t, ws[5], w)
+		require.Equal(t, []*waiter{ws[1], ws[2], ws[3], ws[4]}, dropped)
+		require.Equal(t, int64(0), q.len())
+	})
+
+	main.Run("LIFO", func(t *testing.T) {
+		q := newCodelQueue(10, 5*ms, 100*ms)
+		q.overloaded = true
+		q.intervalStartAt = 0
+		ws := push(t, q, 10*ms, 11*ms, 12*ms)
+
+		w, dropped := q.pop(13 * ms)
+		require.Same(t, ws[2], w)
+		require.Empty(t, dropped)
+
+		w, _ = q.pop(14 * ms)
+		require.Same(t, ws[1], w)
+	})
+
+	main.Run("Recovers", func(t *testing.T) {
+		q := newCodelQueue(10, 5*ms, 100*ms)
+		q.overloaded = true
+		q.intervalStartAt = 0
+
+		ws := push(t, q, 100*ms)
+		w, _ := q.pop(101 * ms)
+		require.Same(t, ws[0], w)
+
+		push(t, q, 150*ms)
+		q.pop(201 * ms)
+		require.False(t, q.overloaded)
+	})
+
+	main.Run("Full", func(t *testing.T) {
+		q := newCodelQueue(1, 5*ms, 100*ms)
+		push(t, q, 0)
+
+		require.False(t, q.push(&waiter{}))
+	})
+}
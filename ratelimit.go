@@ -0,0 +1,65 @@
+package backpressure
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// rateLimiter is a token bucket implemented as GCRA: instead of counting tokens it keeps the theoretical arrival time
+// of the next request, which fits into a single atomic.
+type rateLimiter struct {
+	// tat is the theoretical arrival time in UnixNano format
+	tat int64
+	// interval is the number of nanoseconds between requests at the current rate
+	interval int64
+	burst    int64
+
+	minInterval int64
+	maxInterval int64
+}
+
+func newRateLimiter(rate, minRate float64, burst int64) *rateLimiter {
+	return &rateLimiter{
+		interval:    rateInterval(rate),
+		burst:       burst,
+		minInterval: rateInterval(rate),
+		maxInterval: rateInterval(minRate),
+	}
+}
+
+func (rl *rateLimiter) allow(now int64) bool {
+	for {
+		tat := atomic.LoadInt64(&rl.tat)
+		interval := atomic.LoadInt64(&rl.interval)
+
+		next := max(tat, now)
+		if next-now > (rl.burst-1)*interval {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt64(&rl.tat, tat, next+interval) {
+			return true
+		}
+	}
+}
+
+func (rl *rateLimiter) rate() float64 {
+	return float64(1e9) / float64(atomic.LoadInt64(&rl.interval))
+}
+
+// setRate changes the rate keeping it between the min rate and the initial rate.
+func (rl *rateLimiter) setRate(rate float64) {
+	interval := rateInterval(rate)
+	interval = max(interval, rl.minInterval)
+	interval = min(interval, rl.maxInterval)
+
+	atomic.StoreInt64(&rl.interval, interval)
+}
+
+func rateInterval(rate float64) int64 {
+	if rate <= 0 {
+		return math.MaxInt64
+	}
+
+	return max(int64(1e9/rate), 1)
+}
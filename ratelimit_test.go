@@ -0,0 +1,155 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(main *testing.T) {
+	setUp := func(t *testing.T, cfg Config) (*Backpreassure, *ManualClock) {
+		clock := NewManualClock(time.Unix(1000, 0))
+
+		cfg.DecidePeriod = time.Hour
+		cfg.DecreasePercent = 0.2
+		cfg.IncreasePercent = 0.1
+		cfg.ThresholdPercent = 0.1
+		cfg.MaxMax = 1000
+		cfg.Max = 100
+		cfg.Clock = clock
+
+		bp, err := New(cfg)
+		require.NoError(t, err)
+
+		return bp, clock
+	}
+
+	acquired := func(bp *Backpreassure, n int) int {
+		var cnt int
+		for i := 0; i < n; i++ {
+			tk, allowed := bp.Acquire()
+			if allowed {
+				cnt++
+				bp.Release(tk)
+			}
+		}
+
+		return cnt
+	}
+
+	main.Run("Disabled", func(t *testing.T) {
+		bp, _ := setUp(t, Config{})
+
+		require.Nil(t, bp.rl)
+		require.Equal(t, 1000, acquired(bp, 1000))
+		require.Equal(t, float64(0), bp.Stats().Rate)
+	})
+
+	main.Run("Burst", func(t *testing.T) {
+		bp, clock := setUp(t, Config{
+			Rate:      10,
+			RateBurst: 5,
+		})
+
+		require.Equal(t, 5, acquired(bp, 100))
+
+		clock.Advance(time.Millisecond * 100)
+		require.Equal(t, 1, acquired(bp, 100))
+
+		clock.Advance(time.Second)
+		require.Equal(t, 5, acquired(bp, 100))
+
+		s := bp.Stats()
+		require.Equal(t, float64(10), s.Rate)
+		require.Equal(t, int64(289), s.DeniedReasons[DenyReasonRateLimit])
+	})
+
+	main.Run("Steady", func(t *testing.T) {
+		bp, clock := setUp(t, Config{
+			Rate: 100,
+		})
+
+		var cnt int
+		for i := 0; i < 1000; i++ {
+			cnt += acquired(bp, 2)
+			clock.Advance(time.Millisecond * 5)
+		}
+		require.Equal(t, 500, cnt)
+	})
+
+	main.Run("DenialKeepsConcurrency", func(t *testing.T) {
+		bp, _ := setUp(t, Config{
+			Rate: 1,
+		})
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+
+		t2, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.True(t, t2.Denied)
+		require.Equal(t, DenyReasonRateLimit, t2.DenyReason)
+		require.Equal(t, int64(1), bp.used)
+		require.Equal(t, int64(1), bp.usedMax)
+
+		bp.Release(t2)
+		bp.Release(t1)
+		require.Equal(t, int64(0), bp.used)
+	})
+
+	main.Run("ConcurrencyCheckedFirst", func(t *testing.T) {
+		bp, _ := setUp(t, Config{
+			Rate:      1,
+			RateBurst: 1,
+		})
+		bp.max = 1
+
+		t1, allowed := bp.Acquire()
+		require.True(t, allowed)
+
+		t2, allowed := bp.Acquire()
+		require.False(t, allowed)
+		require.Equal(t, DenyReasonOverLimit, t2.DenyReason)
+
+		bp.Release(t1)
+	})
+
+	main.Run("Adaptive", func(t *testing.T) {
+		bp, _ := setUp(t, Config{
+			Rate:         100,
+			RateAdaptive: true,
+			RateMin:      50,
+		})
+
+		bp.successful = 50
+		bp.congested = 50
+		bp.decide()
+		require.InDelta(t, 80, bp.Stats().Rate, 0.01)
+		require.InDelta(t, 80, bp.DecisionHistory()[0].Rate, 0.01)
+
+		for i := 0; i < 5; i++ {
+			bp.successful = 50
+			bp.congested = 50
+			bp.decide()
+		}
+		require.InDelta(t, 50, bp.Stats().Rate, 0.01)
+
+		for i := 0; i < 20; i++ {
+			bp.successful = 100
+			bp.decide()
+		}
+		require.InDelta(t, 100, bp.Stats().Rate, 0.01)
+	})
+
+	main.Run("NotAdaptive", func(t *testing.T) {
+		bp, _ := setUp(t, Config{
+			Rate: 100,
+		})
+
+		bp.successful = 50
+		bp.congested = 50
+		bp.decide()
+		require.Equal(t, float64(100), bp.Stats().Rate)
+	})
+}
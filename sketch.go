@@ -0,0 +1,144 @@
+package backpressure
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// LatencySketch summarizes recorded latencies so that quantiles can be queried without keeping every value.
+type LatencySketch interface {
+	// Record adds a latency in nanoseconds n times.
+	Record(v, n int64) error
+	// Quantile returns the latency at q, where q is between 0 and 1.
+	Quantile(q float64) int64
+	// Merge adds everything recorded by other. Both sketches must be of the same kind.
+	Merge(other LatencySketch) error
+	// Reset forgets all recorded latencies.
+	Reset()
+}
+
+// HDRSketch is a LatencySketch backed by HdrHistogram.
+// It tracks latencies up to 10 minutes with 1 significant digit, which costs about 5KB per sketch.
+type HDRSketch struct {
+	h *hdrhistogram.Histogram
+}
+
+func NewHDRSketch() *HDRSketch {
+	return &HDRSketch{
+		h: hdrhistogram.New(0, (time.Minute * 10).Nanoseconds(), 1),
+	}
+}
+
+func (s *HDRSketch) Record(v, n int64) error {
+	return s.h.RecordValues(v, n)
+}
+
+func (s *HDRSketch) Quantile(q float64) int64 {
+	return s.h.ValueAtQuantile(q * 100)
+}
+
+func (s *HDRSketch) Merge(other LatencySketch) error {
+	o, ok := other.(*HDRSketch)
+	if !ok {
+		return fmt.Errorf("cannot merge %T into %T", other, s)
+	}
+
+	if dropped := s.h.Merge(o.h); dropped > 0 {
+		return fmt.Errorf("%d values dropped", dropped)
+	}
+
+	return nil
+}
+
+func (s *HDRSketch) Reset() {
+	s.h.Reset()
+}
+
+// MarshalBinary encodes the recorded latencies, so they can be restored with UnmarshalBinary.
+func (s *HDRSketch) MarshalBinary() ([]byte, error) {
+	hs := s.h.Export()
+
+	data := make([]byte, 0, binary.MaxVarintLen64*4+len(hs.Counts))
+	data = binary.AppendVarint(data, hs.LowestTrackableValue)
+	data = binary.AppendVarint(data, hs.HighestTrackableValue)
+	data = binary.AppendVarint(data, hs.SignificantFigures)
+	data = binary.AppendUvarint(data, uint64(len(hs.Counts)))
+	for _, c := range hs.Counts {
+		data = binary.AppendVarint(data, c)
+	}
+
+	return data, nil
+}
+
+func (s *HDRSketch) UnmarshalBinary(data []byte) error {
+	r := varintReader{data: data}
+
+	hs := &hdrhistogram.Snapshot{
+		LowestTrackableValue:  r.varint(),
+		HighestTrackableValue: r.varint(),
+		SignificantFigures:    r.varint(),
+	}
+	n := r.uvarint()
+	if r.err != nil {
+		return r.err
+	}
+
+	if hs.LowestTrackableValue != s.h.LowestTrackableValue() ||
+		hs.HighestTrackableValue != s.h.HighestTrackableValue() ||
+		hs.SignificantFigures != s.h.SignificantFigures() {
+		return fmt.Errorf("histogram range does not match")
+	}
+	if expN := uint64(len(s.h.Export().Counts)); n != expN {
+		return fmt.Errorf("counts length %d does not match %d", n, expN)
+	}
+
+	hs.Counts = make([]int64, n)
+	for i := range hs.Counts {
+		hs.Counts[i] = r.varint()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	s.h = hdrhistogram.Import(hs)
+
+	return nil
+}
+
+type varintReader struct {
+	data []byte
+	err  error
+}
+
+func (r *varintReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("malformed data")
+		return 0
+	}
+	r.data = r.data[n:]
+
+	return v
+}
+
+func (r *varintReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("malformed data")
+		return 0
+	}
+	r.data = r.data[n:]
+
+	return v
+}
@@ -0,0 +1,161 @@
+package backpressure
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is the learned state of a limiter. It is taken before a shutdown and restored at startup,
+// so the limiter does not have to converge from Config.Max again.
+type Snapshot struct {
+	TakenAt time.Time
+	Max     int64
+	UsedMax int64
+	Stats   AIMDStats
+
+	// Latency holds recorded latencies if the latency sketch implements encoding.BinaryMarshaler.
+	Latency []byte `json:",omitempty"`
+}
+
+func (bp *Backpreassure) Snapshot() (Snapshot, error) {
+	s := Snapshot{
+		TakenAt: bp.clock.Now(),
+		Max:     atomic.LoadInt64(&bp.max),
+		UsedMax: atomic.LoadInt64(&bp.usedMax),
+		Stats:   bp.Stats(),
+	}
+
+	if bp.lat != nil {
+		var err error
+		bp.lat.merge(func(ms LatencySketch) {
+			if m, ok := ms.(encoding.BinaryMarshaler); ok {
+				s.Latency, err = m.MarshalBinary()
+			}
+		})
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("latency: %s", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Restore brings back the state taken by Snapshot. Max is kept within MinMax and MaxMax of the current config.
+// An adaptive rate is kept within RateMin and Rate. Latencies are restored only if the latency sketch implements encoding.BinaryUnmarshaler.
+func (bp *Backpreassure) Restore(s Snapshot) error {
+	max := s.Max
+	if max < bp.cfg.MinMax {
+		max = bp.cfg.MinMax
+	}
+	if max > bp.cfg.MaxMax {
+		max = bp.cfg.MaxMax
+	}
+
+	if bp.lat != nil && len(s.Latency) > 0 {
+		if err := bp.lat.restore(s.Latency); err != nil {
+			return fmt.Errorf("latency: %s", err)
+		}
+	}
+
+	bp.decideMux.Lock()
+	atomic.StoreInt64(&bp.max, max)
+	bp.resetIncrease(max)
+	bp.decideMux.Unlock()
+
+	atomic.StoreInt64(&bp.usedMax, s.UsedMax)
+	if bp.rl != nil && bp.cfg.RateAdaptive && s.Stats.Rate > 0 {
+		bp.rl.setRate(s.Stats.Rate)
+	}
+
+	bp.muxStats.Lock()
+	bp.stats = s.Stats
+	bp.stats.Max = max
+	bp.stats.MaxMax = bp.cfg.MaxMax
+	bp.stats.MaxMin = bp.cfg.MinMax
+	bp.stats.PeriodSuccessful = 0
+	bp.stats.PeriodCongested = 0
+	bp.stats.PeriodDenied = 0
+	bp.stats.LatencyP50 = 0
+	bp.stats.LatencyP90 = 0
+	bp.stats.LatencyP99 = 0
+	bp.stats.LatencyMax = 0
+	bp.stats.DeniedReasons = nil
+	bp.muxStats.Unlock()
+
+	for i := range bp.deniedBy {
+		atomic.StoreInt64(&bp.deniedBy[i], s.Stats.DeniedReasons[DenyReason(i)])
+	}
+
+	return nil
+}
+
+// SaveSnapshotFile writes the limiter snapshot to the file. The file is replaced atomically.
+func SaveSnapshotFile(bp *Backpreassure, path string) error {
+	s, err := bp.Snapshot()
+	if err != nil {
+		return fmt.Errorf("snapshot: %s", err)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal: %s", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write: %s", err)
+	}
+	// the data must reach the disk before the rename does, or a crash may leave an empty file behind
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %s", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("rename: %s", err)
+	}
+
+	return nil
+}
+
+// RestoreSnapshotFile restores the limiter from the file written by SaveSnapshotFile.
+// A missing file or a snapshot older than maxAge is ignored, in which case false is returned. Zero maxAge means no age limit.
+func RestoreSnapshotFile(bp *Backpreassure, path string, maxAge time.Duration) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("read: %s", err)
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return false, fmt.Errorf("unmarshal: %s", err)
+	}
+
+	if maxAge > 0 && bp.clock.Now().Sub(s.TakenAt) > maxAge {
+		return false, nil
+	}
+
+	if err := bp.Restore(s); err != nil {
+		return false, fmt.Errorf("restore: %s", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,158 @@
+package backpressure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(main *testing.T) {
+	setUp := func(t *testing.T, newSketch func() LatencySketch) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+
+			DecreaseLatencyPercentile: 0.5,
+			DecreaseLatency:           time.Second,
+			NewLatencySketch:          newSketch,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	record := func(t *testing.T, bp *Backpreassure) {
+		for i := int64(1); i <= 100; i++ {
+			bp.lat.record(i*time.Millisecond.Nanoseconds(), 1)
+		}
+	}
+
+	requireP50 := func(t *testing.T, bp *Backpreassure) {
+		bp.lat.merge(func(ms LatencySketch) {
+			require.InEpsilon(t, 50*time.Millisecond.Nanoseconds(), ms.Quantile(0.5), 0.02)
+		})
+	}
+
+	main.Run("RestoreHDR", func(t *testing.T) {
+		bp := setUp(t, nil)
+		bp.max = 70
+		bp.usedMax = 60
+		bp.successful = 100
+		bp.congested = 5
+		bp.decide()
+		record(t, bp)
+
+		s, err := bp.Snapshot()
+		require.NoError(t, err)
+		require.Equal(t, int64(70), s.Max)
+		require.Equal(t, int64(60), s.UsedMax)
+		require.Equal(t, int64(100), s.Stats.SuccessfulCounter)
+		require.NotEmpty(t, s.Latency)
+
+		bp2 := setUp(t, nil)
+		require.NoError(t, bp2.Restore(s))
+		require.Equal(t, int64(70), bp2.max)
+		require.Equal(t, int64(60), bp2.usedMax)
+		require.Equal(t, int64(100), bp2.Stats().SuccessfulCounter)
+		require.Equal(t, int64(5), bp2.Stats().CongestedCounter)
+		requireP50(t, bp2)
+	})
+
+	main.Run("RestoreDDSketch", func(t *testing.T) {
+		newSketch := func() LatencySketch {
+			return NewDDSketch(0.01)
+		}
+
+		bp := setUp(t, newSketch)
+		record(t, bp)
+
+		s, err := bp.Snapshot()
+		require.NoError(t, err)
+
+		bp2 := setUp(t, newSketch)
+		require.NoError(t, bp2.Restore(s))
+		requireP50(t, bp2)
+	})
+
+	main.Run("RestoreSketchMismatch", func(t *testing.T) {
+		bp := setUp(t, nil)
+		record(t, bp)
+
+		s, err := bp.Snapshot()
+		require.NoError(t, err)
+
+		bp2 := setUp(t, func() LatencySketch {
+			return NewDDSketch(0.01)
+		})
+		record(t, bp2)
+		require.EqualError(t, bp2.Restore(s), `latency: relative accuracy does not match`)
+
+		// latencies recorded so far are kept
+		requireP50(t, bp2)
+	})
+
+	main.Run("RestoreClampsMax", func(t *testing.T) {
+		bp := setUp(t, nil)
+
+		require.NoError(t, bp.Restore(Snapshot{Max: 1000}))
+		require.Equal(t, int64(100), bp.max)
+
+		require.NoError(t, bp.Restore(Snapshot{Max: 0}))
+		require.Equal(t, int64(1), bp.max)
+	})
+
+	main.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bp.json")
+
+		bp := setUp(t, nil)
+		bp.max = 42
+		bp.deny(Token{DenyReason: DenyReasonOverLimit})
+		bp.deny(Token{DenyReason: DenyReasonOverLimit})
+		bp.deny(Token{DenyReason: DenyReasonShutdown})
+		require.NoError(t, SaveSnapshotFile(bp, path))
+
+		bp2 := setUp(t, nil)
+		restored, err := RestoreSnapshotFile(bp2, path, time.Minute)
+		require.NoError(t, err)
+		require.True(t, restored)
+		require.Equal(t, int64(42), bp2.max)
+		require.Equal(t, map[DenyReason]int64{
+			DenyReasonOverLimit: 2,
+			DenyReasonShutdown:  1,
+		}, bp2.Stats().DeniedReasons)
+	})
+
+	main.Run("FileMissing", func(t *testing.T) {
+		bp := setUp(t, nil)
+
+		restored, err := RestoreSnapshotFile(bp, filepath.Join(t.TempDir(), "bp.json"), time.Minute)
+		require.NoError(t, err)
+		require.False(t, restored)
+	})
+
+	main.Run("FileTooOld", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bp.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"TakenAt":"2020-01-01T00:00:00Z","Max":42}`), 0644))
+
+		bp := setUp(t, nil)
+		bp.max = 50
+
+		restored, err := RestoreSnapshotFile(bp, path, time.Hour)
+		require.NoError(t, err)
+		require.False(t, restored)
+		require.Equal(t, int64(50), bp.max)
+
+		restored, err = RestoreSnapshotFile(bp, path, 0)
+		require.NoError(t, err)
+		require.True(t, restored)
+		require.Equal(t, int64(42), bp.max)
+	})
+}
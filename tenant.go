@@ -0,0 +1,201 @@
+package backpressure
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// tenantIdlePeriods defines after how many periods without requests a tenant is forgotten.
+const tenantIdlePeriods = 10
+
+type TenantStats struct {
+	Key    string
+	Weight float64
+	// Share is the part of max guaranteed to the tenant at the moment.
+	Share int64
+	Used  int64
+
+	// Counters are cumulative and include the period in progress.
+	SuccessfulCounter int64
+	CongestedCounter  int64
+	DeniedCounter     int64
+}
+
+type tenant struct {
+	key string
+
+	used       int64
+	successful int64
+	congested  int64
+	denied     int64
+
+	// guarded by fairShare.mux
+	weight     float64
+	seenPeriod int64
+}
+
+// fairShare divides max among tenants active in the current or the previous period in proportion to their weights.
+type fairShare struct {
+	mux          sync.Mutex
+	tenants      map[string]*tenant
+	period       int64
+	activeWeight float64
+}
+
+func newFairShare() *fairShare {
+	return &fairShare{
+		tenants: make(map[string]*tenant),
+	}
+}
+
+// tenant returns the tenant and the fraction of max it is guaranteed, marking the tenant active.
+func (fs *fairShare) tenant(key string, weight float64) (*tenant, float64) {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	t, ok := fs.tenants[key]
+	if !ok {
+		t = &tenant{
+			key:        key,
+			seenPeriod: math.MinInt64,
+		}
+		fs.tenants[key] = t
+	}
+
+	if t.seenPeriod < fs.period-1 {
+		fs.activeWeight += weight
+	} else {
+		fs.activeWeight += weight - t.weight
+	}
+	t.weight = weight
+	t.seenPeriod = fs.period
+
+	return t, weight / fs.activeWeight
+}
+
+// nextPeriod recalculates active tenants and forgets long idle ones.
+func (fs *fairShare) nextPeriod() {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	fs.period++
+	fs.activeWeight = 0
+	for key, t := range fs.tenants {
+		if t.seenPeriod >= fs.period-1 {
+			fs.activeWeight += t.weight
+		} else if t.seenPeriod < fs.period-tenantIdlePeriods && atomic.LoadInt64(&t.used) == 0 {
+			delete(fs.tenants, key)
+		}
+	}
+}
+
+// stats returns the top n tenants by used capacity and denials.
+func (fs *fairShare) stats(maxCap int64, n int) []TenantStats {
+	fs.mux.Lock()
+	if len(fs.tenants) == 0 {
+		fs.mux.Unlock()
+		return nil
+	}
+
+	ts := make([]TenantStats, 0, len(fs.tenants))
+	for _, t := range fs.tenants {
+		var share int64
+		if t.seenPeriod >= fs.period-1 {
+			share = tenantShare(maxCap, t.weight/fs.activeWeight)
+		}
+
+		ts = append(ts, TenantStats{
+			Key:               t.key,
+			Weight:            t.weight,
+			Share:             share,
+			Used:              atomic.LoadInt64(&t.used),
+			SuccessfulCounter: atomic.LoadInt64(&t.successful),
+			CongestedCounter:  atomic.LoadInt64(&t.congested),
+			DeniedCounter:     atomic.LoadInt64(&t.denied),
+		})
+	}
+	fs.mux.Unlock()
+
+	sort.Slice(ts, func(i, j int) bool {
+		if ts[i].Used != ts[j].Used {
+			return ts[i].Used > ts[j].Used
+		}
+		if ts[i].DeniedCounter != ts[j].DeniedCounter {
+			return ts[i].DeniedCounter > ts[j].DeniedCounter
+		}
+		return ts[i].Key < ts[j].Key
+	})
+	if len(ts) > n {
+		ts = ts[:n]
+	}
+
+	return ts
+}
+
+func (t *tenant) release(congested bool) {
+	atomic.AddInt64(&t.used, -1)
+	if !congested {
+		atomic.AddInt64(&t.successful, 1)
+	} else {
+		atomic.AddInt64(&t.congested, 1)
+	}
+}
+
+func (fs *fairShare) resetStats() {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	for _, t := range fs.tenants {
+		atomic.StoreInt64(&t.successful, 0)
+		atomic.StoreInt64(&t.congested, 0)
+		atomic.StoreInt64(&t.denied, 0)
+	}
+}
+
+func tenantShare(maxCap int64, fraction float64) int64 {
+	return max(int64(math.Ceil(float64(maxCap)*fraction)), 1)
+}
+
+// AcquireTenant is like Acquire but shares max fairly among tenants.
+// Max is divided among tenants active in the current or the previous period in proportion to their weights.
+// A tenant may use more than its share while capacity is idle, but not the last TenantReservePercent of max,
+// which is kept for tenants returning to their share. Non-positive weight is treated as 1.
+func (bp *Backpreassure) AcquireTenant(key string, weight float64) (Token, bool) {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	tn, fraction := bp.fs.tenant(key, weight)
+
+	var t Token
+	allowed := bp.acquire(acquireReq{
+		priority:       PriorityDefault,
+		tenant:         tn,
+		tenantFraction: fraction,
+	}, &t)
+	if !allowed {
+		atomic.AddInt64(&tn.denied, 1)
+		bp.deny(t)
+	}
+
+	return t, allowed
+}
+
+// takeTenant takes one slot of the tenant if it is within its share or may borrow idle capacity. used includes the slot.
+// Throttling does not enforce max, so there are no shares and the slot only tracks tenant usage.
+func (bp *Backpreassure) takeTenant(tn *tenant, used, maxCap int64, fraction float64) bool {
+	tenantUsed := atomic.AddInt64(&tn.used, 1)
+	if bp.th != nil || tenantUsed <= tenantShare(maxCap, fraction) {
+		return true
+	}
+
+	reserve := int64(math.Ceil(float64(maxCap) * bp.cfg.TenantReservePercent))
+	if used <= maxCap-reserve {
+		return true
+	}
+
+	atomic.AddInt64(&tn.used, -1)
+	return false
+}
@@ -0,0 +1,160 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenant(main *testing.T) {
+	setUp := func(t *testing.T) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    10,
+
+			TenantReservePercent: 0.2,
+			TenantStatsTop:       2,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	acquire := func(t *testing.T, bp *Backpreassure, key string, weight float64, n int) ([]Token, DenyReason) {
+		var ts []Token
+		var reason DenyReason
+		for i := 0; i < n; i++ {
+			tk, allowed := bp.AcquireTenant(key, weight)
+			require.Equal(t, key, tk.Tenant)
+			if !allowed {
+				reason = tk.DenyReason
+				continue
+			}
+
+			ts = append(ts, tk)
+		}
+
+		return ts, reason
+	}
+
+	main.Run("Defaults", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 0.1, bp.cfg.TenantReservePercent)
+		require.Equal(t, 10, bp.cfg.TenantStatsTop)
+		require.Nil(t, bp.Stats().Tenants)
+	})
+
+	main.Run("SingleTenantUsesWholeMax", func(t *testing.T) {
+		bp := setUp(t)
+
+		ts, reason := acquire(t, bp, "a", 1, 11)
+		require.Len(t, ts, 10)
+		require.Equal(t, DenyReasonOverLimit, reason)
+	})
+
+	main.Run("WeightedShares", func(t *testing.T) {
+		bp := setUp(t)
+
+		bts, _ := acquire(t, bp, "b", 3, 1)
+		require.Len(t, bts, 1)
+
+		// a is guaranteed 3 and may borrow up to 8 used in total
+		ats, reason := acquire(t, bp, "a", 1, 10)
+		require.Len(t, ats, 7)
+		require.Equal(t, DenyReasonTenantShare, reason)
+
+		// b is within its share of 8 and takes the reserve
+		bts, reason = acquire(t, bp, "b", 3, 5)
+		require.Len(t, bts, 2)
+		require.Equal(t, DenyReasonOverLimit, reason)
+	})
+
+	main.Run("BorrowedShareIsGivenBack", func(t *testing.T) {
+		bp := setUp(t)
+
+		bp.fs.tenant("b", 1)
+		ats, _ := acquire(t, bp, "a", 1, 10)
+		require.Len(t, ats, 8)
+
+		bts, _ := acquire(t, bp, "b", 1, 5)
+		require.Len(t, bts, 2)
+
+		// a is over its share of 5, the capacity it releases goes to b
+		bp.Release(ats[0])
+		_, reason := acquire(t, bp, "a", 1, 1)
+		require.Equal(t, DenyReasonTenantShare, reason)
+
+		bts, _ = acquire(t, bp, "b", 1, 1)
+		require.Len(t, bts, 1)
+	})
+
+	main.Run("IdleTenantIsNotActive", func(t *testing.T) {
+		bp := setUp(t)
+
+		bp.fs.tenant("b", 1)
+		bp.fs.nextPeriod()
+		bp.fs.nextPeriod()
+
+		ts, reason := acquire(t, bp, "a", 1, 11)
+		require.Len(t, ts, 10)
+		require.Equal(t, DenyReasonOverLimit, reason)
+		require.Len(t, bp.Stats().Tenants, 2)
+
+		for _, tk := range ts {
+			bp.Release(tk)
+		}
+		for i := 0; i <= tenantIdlePeriods; i++ {
+			bp.fs.nextPeriod()
+		}
+		require.Empty(t, bp.Stats().Tenants)
+	})
+
+	main.Run("Stats", func(t *testing.T) {
+		bp := setUp(t)
+
+		acquire(t, bp, "a", 1, 1)
+		bts, _ := acquire(t, bp, "b", 2, 3)
+		cts, _ := acquire(t, bp, "c", 1, 2)
+		bts[0].Congested = true
+		bp.Release(bts[0])
+		bp.Release(cts[0])
+		acquire(t, bp, "c", 1, 10)
+
+		s := bp.Stats()
+		require.Equal(t, []TenantStats{
+			{
+				Key:               "c",
+				Weight:            1,
+				Share:             3,
+				Used:              5,
+				SuccessfulCounter: 1,
+				DeniedCounter:     6,
+			},
+			{
+				Key:              "b",
+				Weight:           2,
+				Share:            5,
+				Used:             2,
+				CongestedCounter: 1,
+			},
+		}, s.Tenants)
+		require.Equal(t, int64(6), s.DeniedReasons[DenyReasonTenantShare])
+
+		bp.ResetStats()
+		s = bp.Stats()
+		require.Equal(t, int64(0), s.Tenants[0].DeniedCounter)
+		require.Equal(t, int64(5), s.Tenants[0].Used)
+	})
+}
@@ -0,0 +1,81 @@
+package backpressure
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// throttle implements client-side adaptive throttling from the Google SRE book.
+// It keeps requests and accepts over a sliding window of decide periods and rejects locally
+// with probability max(0, (requests - K*accepts) / (requests + 1)).
+// Requests are successful, congested and denied ones, accepts are successful ones.
+type throttle struct {
+	k float64
+
+	// buckets hold per-period counts of the window. They are written by decide only.
+	buckets  []throttleBucket
+	next     int
+	interval time.Duration
+	lastAt   time.Time
+
+	requests int64
+	accepts  int64
+}
+
+type throttleBucket struct {
+	requests int64
+	accepts  int64
+}
+
+func newThrottle(k float64, periods int, interval time.Duration, now time.Time) *throttle {
+	return &throttle{
+		k:        k,
+		buckets:  make([]throttleBucket, periods),
+		interval: interval,
+		lastAt:   now,
+	}
+}
+
+// period moves the counts of a finished period into the window, dropping the oldest period.
+// Whole intervals passed since the previous period without a decide count as empty periods,
+// so the window does not hold counts older than itself after the limiter was idle.
+func (th *throttle) period(requests, accepts int64, now time.Time) {
+	skipped := int(now.Sub(th.lastAt)/th.interval) - 1
+	for i := 0; i < min(skipped, len(th.buckets)); i++ {
+		th.push(0, 0)
+	}
+	th.lastAt = now
+
+	th.push(requests, accepts)
+}
+
+func (th *throttle) push(requests, accepts int64) {
+	b := &th.buckets[th.next]
+	atomic.AddInt64(&th.requests, requests-b.requests)
+	atomic.AddInt64(&th.accepts, accepts-b.accepts)
+	b.requests = requests
+	b.accepts = accepts
+
+	th.next = (th.next + 1) % len(th.buckets)
+}
+
+// probability returns the rejection probability given the counts of the period in progress.
+func (th *throttle) probability(requests, accepts int64) float64 {
+	requests += atomic.LoadInt64(&th.requests)
+	accepts += atomic.LoadInt64(&th.accepts)
+
+	return math.Max(0, (float64(requests)-th.k*float64(accepts))/float64(requests+1))
+}
+
+func (bp *Backpreassure) throttleProbability() float64 {
+	successful := atomic.LoadInt64(&bp.successful)
+
+	return bp.th.probability(successful+atomic.LoadInt64(&bp.congested)+atomic.LoadInt64(&bp.denied), successful)
+}
+
+func (bp *Backpreassure) throttled() bool {
+	p := bp.throttleProbability()
+	return p > 0 && rand.Float64() < p
+}
@@ -0,0 +1,196 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(main *testing.T) {
+	setUp := func(t *testing.T) *Backpreassure {
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 1,
+			MaxMax: 100,
+			Max:    1,
+
+			ThrottleK:      2,
+			ThrottleWindow: time.Hour * 3,
+		})
+		require.NoError(t, err)
+
+		return bp
+	}
+
+	main.Run("Window", func(t *testing.T) {
+		now := time.Unix(1000, 0)
+		th := newThrottle(2, 3, time.Second, now)
+		require.Equal(t, float64(0), th.probability(0, 0))
+
+		period := func(requests, accepts int64) {
+			now = now.Add(time.Second)
+			th.period(requests, accepts, now)
+		}
+
+		period(100, 0)
+		require.InDelta(t, 100.0/101, th.probability(0, 0), 0.0001)
+
+		period(100, 100)
+		require.Equal(t, float64(0), th.probability(0, 0))
+
+		period(100, 0)
+		require.InDelta(t, 100.0/301, th.probability(0, 0), 0.0001)
+
+		// the first period leaves the window
+		period(100, 50)
+		require.InDelta(t, 0, th.probability(0, 0), 0.0001)
+		require.InDelta(t, 100.0/401, th.probability(100, 0), 0.0001)
+	})
+
+	main.Run("WindowSkipsIdlePeriods", func(t *testing.T) {
+		now := time.Unix(1000, 0)
+		th := newThrottle(2, 3, time.Second, now)
+
+		now = now.Add(time.Second)
+		th.period(100, 0, now)
+		now = now.Add(time.Second)
+		th.period(100, 0, now)
+
+		// a period passed without a decide, it and the finished one push the oldest period out
+		now = now.Add(time.Second * 2)
+		th.period(0, 0, now)
+		require.InDelta(t, 100.0/101, th.probability(0, 0), 0.0001)
+
+		// an idle hour empties the window
+		now = now.Add(time.Hour)
+		th.period(10, 10, now)
+		require.Equal(t, float64(0), th.probability(0, 0))
+		require.Equal(t, int64(10), th.requests)
+	})
+
+	main.Run("Defaults", func(t *testing.T) {
+		bp, err := New(Config{
+			DecidePeriod:    time.Second * 7,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+			ThrottleK:       2,
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, time.Minute*2, bp.cfg.ThrottleWindow)
+		require.Len(t, bp.th.buckets, 18)
+	})
+
+	main.Run("IgnoresMax", func(t *testing.T) {
+		bp := setUp(t)
+
+		for i := 0; i < 10; i++ {
+			_, allowed := bp.Acquire()
+			require.True(t, allowed)
+		}
+		require.Equal(t, int64(10), bp.used)
+		require.Equal(t, float64(0), bp.Stats().ThrottleProbability)
+	})
+
+	main.Run("MaxFeaturesRejected", func(t *testing.T) {
+		for field, cfg := range map[string]Config{
+			"DefaultPriorityPercent":   {DefaultPriorityPercent: 0.8},
+			"SheddablePriorityPercent": {SheddablePriorityPercent: 0.5},
+			"Partitions":               {Partitions: map[string]float64{"a": 0.5}},
+			"WarmUp":                   {WarmUp: time.Minute},
+		} {
+			cfg.DecidePeriod = time.Second
+			cfg.DecreasePercent = 0.20
+			cfg.IncreasePercent = 0.10
+			cfg.ThrottleK = 2
+
+			bp, err := New(cfg)
+			require.EqualError(t, err, field+`: cannot be used together with ThrottleK`)
+			require.Nil(t, bp)
+		}
+
+		// a config returned by Config can be used again
+		bp := setUp(t)
+		bp2, err := New(bp.Config())
+		require.NoError(t, err)
+		require.NoError(t, bp2.Close())
+	})
+
+	main.Run("TenantsIgnoreMax", func(t *testing.T) {
+		bp := setUp(t)
+
+		for i := 0; i < 10; i++ {
+			_, allowed := bp.AcquireTenant("a", 1)
+			require.True(t, allowed)
+		}
+		require.Equal(t, int64(10), bp.Stats().Tenants[0].Used)
+	})
+
+	main.Run("RejectsWhenCongested", func(t *testing.T) {
+		bp := setUp(t)
+
+		bp.successful = 100
+		bp.congested = 900
+		bp.decide()
+
+		// (1000 - 2*100) / 1001
+		require.InDelta(t, 0.8, bp.Stats().ThrottleProbability, 0.001)
+
+		var allowed int
+		for i := 0; i < 1000; i++ {
+			tk, ok := bp.Acquire()
+			if ok {
+				allowed++
+			} else {
+				require.Equal(t, DenyReasonThrottled, tk.DenyReason)
+			}
+		}
+
+		intInRange(t, 100, 300, allowed)
+		require.Equal(t, int64(1000-allowed), bp.Stats().DeniedReasons[DenyReasonThrottled])
+	})
+
+	main.Run("Recovers", func(t *testing.T) {
+		bp := setUp(t)
+
+		bp.successful = 100
+		bp.congested = 900
+		bp.decide()
+
+		bp.successful = 1000
+		bp.decide()
+		require.Equal(t, float64(0), bp.Stats().ThrottleProbability)
+	})
+
+	main.Run("IdleExpires", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp, err := New(Config{
+			DecidePeriod:    time.Second,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+			MinMax:          1,
+			Max:             1,
+
+			ThrottleK:      2,
+			ThrottleWindow: time.Second * 10,
+
+			Clock: clock,
+		})
+		require.NoError(t, err)
+
+		clock.Advance(time.Second)
+		bp.successful = 100
+		bp.congested = 900
+		bp.decide()
+		require.InDelta(t, 0.8, bp.Stats().ThrottleProbability, 0.001)
+
+		clock.Advance(time.Hour)
+		bp.decide()
+		require.Equal(t, float64(0), bp.Stats().ThrottleProbability)
+	})
+}
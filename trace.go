@@ -0,0 +1,309 @@
+package backpressure
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type TraceOutcome string
+
+const (
+	TraceOK        TraceOutcome = "ok"
+	TraceCongested TraceOutcome = "congested"
+	TraceDenied    TraceOutcome = "denied"
+)
+
+// TraceRecord describes a single request passed through a limiter. Times are in UnixNano format.
+type TraceRecord struct {
+	AcquireAt int64        `json:"acquire_at"`
+	ReleaseAt int64        `json:"release_at"`
+	Outcome   TraceOutcome `json:"outcome"`
+	Used      int64        `json:"used"`
+	Max       int64        `json:"max"`
+}
+
+// TraceWriter writes trace records as JSON lines. It is safe for concurrent use.
+type TraceWriter struct {
+	mux sync.Mutex
+	enc *json.Encoder
+}
+
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	return &TraceWriter{
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (w *TraceWriter) Write(r TraceRecord) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.enc.Encode(r)
+}
+
+// TraceReader reads trace records written by TraceWriter.
+type TraceReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func NewTraceReader(r io.Reader) *TraceReader {
+	return &TraceReader{
+		s: bufio.NewScanner(r),
+	}
+}
+
+// Read returns the next record or io.EOF when there are no more records.
+func (r *TraceReader) Read() (TraceRecord, error) {
+	for r.s.Scan() {
+		r.line++
+		if len(r.s.Bytes()) == 0 {
+			continue
+		}
+
+		var rec TraceRecord
+		if err := json.Unmarshal(r.s.Bytes(), &rec); err != nil {
+			return TraceRecord{}, fmt.Errorf("line %d: %s", r.line, err)
+		}
+
+		return rec, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return TraceRecord{}, err
+	}
+
+	return TraceRecord{}, io.EOF
+}
+
+// ReadAll reads records until io.EOF.
+func (r *TraceReader) ReadAll() ([]TraceRecord, error) {
+	var recs []TraceRecord
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		recs = append(recs, rec)
+	}
+}
+
+// TraceRecorder passes requests through a limiter and writes a trace record for each of them.
+type TraceRecorder struct {
+	bp *Backpreassure
+	w  *TraceWriter
+}
+
+// TracedToken is a token acquired through a TraceRecorder.
+type TracedToken struct {
+	Token
+	AcquireAt time.Time
+}
+
+func NewTraceRecorder(bp *Backpreassure, w *TraceWriter) *TraceRecorder {
+	return &TraceRecorder{
+		bp: bp,
+		w:  w,
+	}
+}
+
+func (r *TraceRecorder) Acquire() (TracedToken, bool) {
+	now := r.bp.clock.Now()
+	t, allowed := r.bp.Acquire()
+	if !allowed {
+		r.write(TraceRecord{
+			AcquireAt: now.UnixNano(),
+			ReleaseAt: now.UnixNano(),
+			Outcome:   TraceDenied,
+			Used:      t.Used,
+			Max:       t.Max,
+		})
+	}
+
+	return TracedToken{
+		Token:     t,
+		AcquireAt: now,
+	}, allowed
+}
+
+func (r *TraceRecorder) Release(t TracedToken) {
+	r.bp.Release(t.Token)
+
+	outcome := TraceOK
+	if t.Congested {
+		outcome = TraceCongested
+	}
+
+	r.write(TraceRecord{
+		AcquireAt: t.AcquireAt.UnixNano(),
+		ReleaseAt: r.bp.clock.Now().UnixNano(),
+		Outcome:   outcome,
+		Used:      t.Used,
+		Max:       t.Max,
+	})
+}
+
+func (r *TraceRecorder) write(rec TraceRecord) {
+	if err := r.w.Write(rec); err != nil {
+		log.Printf("[ERROR] backpressure: trace: write: %s", err)
+	}
+}
+
+type ReplayReport struct {
+	Requests int64
+	// Admitted and Denied count requests admitted and denied by the replayed config.
+	Admitted int64
+	Denied   int64
+	// NewlyDenied counts requests admitted in the trace but denied by the replayed config.
+	NewlyDenied int64
+	// NewlyAdmitted counts requests denied in the trace but admitted by the replayed config.
+	// Their outcome is unknown, they are replayed as successful requests released right away.
+	NewlyAdmitted int64
+	Successful    int64
+	Congested     int64
+
+	Periods []ReplayPeriod
+}
+
+// ReplayPeriod holds what happened within a decide period.
+type ReplayPeriod struct {
+	At         time.Time
+	Max        int64
+	InFlight   int64
+	Successful int64
+	Congested  int64
+	Denied     int64
+}
+
+type replayEventKind int
+
+// the order defines which of the events happening at the same time is handled first
+const (
+	replayRelease replayEventKind = iota
+	replayPeriod
+	replayAcquire
+)
+
+type replayEvent struct {
+	at   int64
+	kind replayEventKind
+	idx  int
+}
+
+// ReplayTrace feeds recorded requests through a new limiter with the given config in virtual time.
+// Requests are acquired and released at the recorded times with the recorded outcomes.
+// The config Clock is replaced with a ManualClock and the Cluster is dropped.
+func ReplayTrace(cfg Config, recs []TraceRecord) (ReplayReport, error) {
+	if len(recs) == 0 {
+		return ReplayReport{}, nil
+	}
+
+	events := make([]replayEvent, 0, len(recs)*2)
+	start, end := recs[0].AcquireAt, recs[0].ReleaseAt
+	for i, rec := range recs {
+		if rec.ReleaseAt < rec.AcquireAt {
+			return ReplayReport{}, fmt.Errorf("record %d: released before acquired", i)
+		}
+
+		events = append(events, replayEvent{at: rec.AcquireAt, kind: replayAcquire, idx: i})
+		if rec.Outcome != TraceDenied {
+			events = append(events, replayEvent{at: rec.ReleaseAt, kind: replayRelease, idx: i})
+		}
+
+		start = min(start, rec.AcquireAt)
+		end = max(end, rec.ReleaseAt)
+	}
+
+	clock := NewManualClock(time.Unix(0, start))
+	cfg.Clock = clock
+	cfg.Cluster = nil
+	bp, err := New(cfg)
+	if err != nil {
+		return ReplayReport{}, err
+	}
+	defer bp.Close()
+
+	for at := start + cfg.DecidePeriod.Nanoseconds(); at <= end; at += cfg.DecidePeriod.Nanoseconds() {
+		events = append(events, replayEvent{at: at, kind: replayPeriod})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		if events[i].kind != events[j].kind {
+			return events[i].kind < events[j].kind
+		}
+		return events[i].idx < events[j].idx
+	})
+
+	rep := ReplayReport{}
+	tokens := make(map[int]Token)
+	period := ReplayPeriod{}
+	var lastMax int64
+
+	for _, e := range events {
+		clock.Set(time.Unix(0, e.at))
+
+		switch e.kind {
+		case replayAcquire:
+			rec := recs[e.idx]
+			rep.Requests++
+
+			t, allowed := bp.Acquire()
+			lastMax = t.Max
+			if !allowed {
+				rep.Denied++
+				period.Denied++
+				if rec.Outcome != TraceDenied {
+					rep.NewlyDenied++
+				}
+				continue
+			}
+
+			rep.Admitted++
+			if rec.Outcome == TraceDenied {
+				rep.NewlyAdmitted++
+				rep.Successful++
+				period.Successful++
+				bp.Release(t)
+				continue
+			}
+
+			tokens[e.idx] = t
+		case replayRelease:
+			t, ok := tokens[e.idx]
+			if !ok {
+				continue
+			}
+			delete(tokens, e.idx)
+
+			t.Congested = recs[e.idx].Outcome == TraceCongested
+			if t.Congested {
+				rep.Congested++
+				period.Congested++
+			} else {
+				rep.Successful++
+				period.Successful++
+			}
+			bp.Release(t)
+		case replayPeriod:
+			period.At = time.Unix(0, e.at)
+			period.Max = lastMax
+			period.InFlight = int64(len(tokens))
+			rep.Periods = append(rep.Periods, period)
+
+			period = ReplayPeriod{}
+		}
+	}
+
+	return rep, nil
+}
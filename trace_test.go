@@ -0,0 +1,155 @@
+package backpressure
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(main *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cfg := func() Config {
+		return Config{
+			DecidePeriod:     time.Second,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+			MaxMax:           100,
+			Max:              10,
+		}
+	}
+
+	main.Run("WriteRead", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		w := NewTraceWriter(buf)
+		require.NoError(t, w.Write(TraceRecord{AcquireAt: 1, ReleaseAt: 2, Outcome: TraceOK, Used: 3, Max: 4}))
+		require.NoError(t, w.Write(TraceRecord{AcquireAt: 5, ReleaseAt: 5, Outcome: TraceDenied, Used: 6, Max: 5}))
+		require.Equal(t, `{"acquire_at":1,"release_at":2,"outcome":"ok","used":3,"max":4}
+{"acquire_at":5,"release_at":5,"outcome":"denied","used":6,"max":5}
+`, buf.String())
+
+		recs, err := NewTraceReader(buf).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, []TraceRecord{
+			{AcquireAt: 1, ReleaseAt: 2, Outcome: TraceOK, Used: 3, Max: 4},
+			{AcquireAt: 5, ReleaseAt: 5, Outcome: TraceDenied, Used: 6, Max: 5},
+		}, recs)
+	})
+
+	main.Run("ReadMalformed", func(t *testing.T) {
+		r := NewTraceReader(strings.NewReader("{\"acquire_at\":1}\n\nnot json\n"))
+
+		_, err := r.Read()
+		require.NoError(t, err)
+
+		_, err = r.Read()
+		require.EqualError(t, err, `line 3: invalid character 'o' in literal null (expecting 'u')`)
+	})
+
+	main.Run("Recorder", func(t *testing.T) {
+		c := NewManualClock(start)
+
+		lcfg := cfg()
+		lcfg.Max = 1
+		lcfg.Clock = c
+		bp, err := New(lcfg)
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		r := NewTraceRecorder(bp, NewTraceWriter(buf))
+
+		t1, allowed := r.Acquire()
+		require.True(t, allowed)
+
+		c.Advance(time.Millisecond)
+		_, allowed = r.Acquire()
+		require.False(t, allowed)
+
+		c.Advance(time.Millisecond)
+		t1.Congested = true
+		r.Release(t1)
+
+		tr := NewTraceReader(buf)
+
+		rec, err := tr.Read()
+		require.NoError(t, err)
+		require.Equal(t, TraceRecord{
+			AcquireAt: start.Add(time.Millisecond).UnixNano(),
+			ReleaseAt: start.Add(time.Millisecond).UnixNano(),
+			Outcome:   TraceDenied,
+			Used:      2,
+			Max:       1,
+		}, rec)
+
+		rec, err = tr.Read()
+		require.NoError(t, err)
+		require.Equal(t, TraceRecord{
+			AcquireAt: start.UnixNano(),
+			ReleaseAt: start.Add(time.Millisecond * 2).UnixNano(),
+			Outcome:   TraceCongested,
+			Used:      1,
+			Max:       1,
+		}, rec)
+
+		_, err = tr.Read()
+		require.Equal(t, io.EOF, err)
+	})
+
+	main.Run("ReplayEmpty", func(t *testing.T) {
+		rep, err := ReplayTrace(cfg(), nil)
+		require.NoError(t, err)
+		require.Equal(t, ReplayReport{}, rep)
+	})
+
+	main.Run("ReplayInvalidRecord", func(t *testing.T) {
+		_, err := ReplayTrace(cfg(), []TraceRecord{{AcquireAt: 2, ReleaseAt: 1}})
+		require.EqualError(t, err, `record 0: released before acquired`)
+	})
+
+	main.Run("Replay", func(t *testing.T) {
+		// 20 concurrent requests every 100ms for 3 seconds, the last second is congested
+		var recs []TraceRecord
+		for i := 0; i < 30; i++ {
+			at := start.Add(time.Millisecond * 100 * time.Duration(i))
+			for j := 0; j < 20; j++ {
+				outcome := TraceOK
+				if i >= 20 {
+					outcome = TraceCongested
+				}
+				if j == 19 {
+					outcome = TraceDenied
+				}
+
+				recs = append(recs, TraceRecord{
+					AcquireAt: at.UnixNano(),
+					ReleaseAt: at.Add(time.Millisecond * 50).UnixNano(),
+					Outcome:   outcome,
+				})
+			}
+		}
+
+		rep, err := ReplayTrace(cfg(), recs)
+		require.NoError(t, err)
+
+		require.Equal(t, int64(600), rep.Requests)
+		require.Equal(t, rep.Requests, rep.Admitted+rep.Denied)
+		require.Equal(t, int64(10*10+12*10+14*10), rep.Admitted)
+		require.Equal(t, int64(0), rep.NewlyAdmitted)
+		require.Equal(t, rep.Denied-30, rep.NewlyDenied)
+		require.Equal(t, int64(140), rep.Congested)
+
+		require.Len(t, rep.Periods, 2)
+		require.True(t, start.Add(time.Second).Equal(rep.Periods[0].At))
+		require.True(t, start.Add(time.Second*2).Equal(rep.Periods[1].At))
+
+		rep.Periods[0].At = time.Time{}
+		rep.Periods[1].At = time.Time{}
+		require.Equal(t, ReplayPeriod{Max: 10, Successful: 100, Denied: 100}, rep.Periods[0])
+		require.Equal(t, ReplayPeriod{Max: 12, Successful: 120, Denied: 80}, rep.Periods[1])
+	})
+}
@@ -0,0 +1,60 @@
+package backpressure
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+type WarmUpCurve string
+
+const (
+	// WarmUpLinear ramps the effective max by the same amount over time.
+	WarmUpLinear WarmUpCurve = "linear"
+	// WarmUpExponential ramps the effective max by the same factor over time, slowly at first and fast at the end.
+	WarmUpExponential WarmUpCurve = "exponential"
+)
+
+// WarmUp starts the Config.WarmUp ramp over again, for example when the number of upstream instances changes.
+// It does nothing if WarmUp is not configured.
+func (bp *Backpreassure) WarmUp() {
+	if bp.cfg.WarmUp <= 0 {
+		return
+	}
+
+	atomic.StoreInt64(&bp.warmUpEndAt, bp.clock.Now().UnixNano()+bp.cfg.WarmUp.Nanoseconds())
+}
+
+// effectiveMax returns max limited by the warm-up ramp.
+func (bp *Backpreassure) effectiveMax() int64 {
+	maxCap := atomic.LoadInt64(&bp.max)
+
+	endAt := atomic.LoadInt64(&bp.warmUpEndAt)
+	if endAt == 0 {
+		return maxCap
+	}
+
+	left := endAt - bp.clock.Now().UnixNano()
+	if left <= 0 {
+		atomic.CompareAndSwapInt64(&bp.warmUpEndAt, endAt, 0)
+		return maxCap
+	}
+
+	return warmUpMax(bp.cfg.WarmUpCurve, bp.cfg.MinMax, maxCap, 1-float64(left)/float64(bp.cfg.WarmUp.Nanoseconds()))
+}
+
+// warmUpMax ramps from minMax at progress 0 to maxCap at progress 1.
+func warmUpMax(curve WarmUpCurve, minMax, maxCap int64, progress float64) int64 {
+	if minMax >= maxCap {
+		return maxCap
+	}
+
+	var v float64
+	switch curve {
+	case WarmUpExponential:
+		v = float64(minMax) * math.Pow(float64(maxCap)/float64(minMax), progress)
+	default:
+		v = float64(minMax) + float64(maxCap-minMax)*progress
+	}
+
+	return min(max(int64(v), minMax), maxCap)
+}
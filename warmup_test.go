@@ -0,0 +1,107 @@
+package backpressure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmUp(main *testing.T) {
+	setUp := func(t *testing.T, curve WarmUpCurve) (*Backpreassure, *ManualClock) {
+		clock := NewManualClock(time.Unix(1000, 0))
+		bp, err := New(Config{
+			DecidePeriod:     time.Hour,
+			DecreasePercent:  0.20,
+			IncreasePercent:  0.10,
+			ThresholdPercent: 0.10,
+
+			MinMax: 10,
+			MaxMax: 1000,
+			Max:    110,
+
+			WarmUp:      time.Second * 10,
+			WarmUpCurve: curve,
+			Clock:       clock,
+		})
+		require.NoError(t, err)
+
+		return bp, clock
+	}
+
+	acquire := func(bp *Backpreassure) int {
+		var n int
+		for {
+			if _, allowed := bp.Acquire(); !allowed {
+				return n
+			}
+			n++
+		}
+	}
+
+	main.Run("Defaults", func(t *testing.T) {
+		bp, _ := setUp(t, "")
+		require.Equal(t, WarmUpLinear, bp.cfg.WarmUpCurve)
+
+		bp, err := New(Config{
+			DecidePeriod:    time.Hour,
+			DecreasePercent: 0.20,
+			IncreasePercent: 0.10,
+			Max:             10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, WarmUpCurve(""), bp.cfg.WarmUpCurve)
+		require.Equal(t, int64(10), bp.Stats().EffectiveMax)
+
+		bp.WarmUp()
+		require.Equal(t, int64(0), bp.warmUpEndAt)
+	})
+
+	main.Run("Linear", func(t *testing.T) {
+		bp, clock := setUp(t, WarmUpLinear)
+
+		require.Equal(t, int64(10), bp.effectiveMax())
+		clock.Advance(time.Second * 5)
+		require.Equal(t, int64(60), bp.effectiveMax())
+
+		require.Equal(t, 60, acquire(bp))
+		s := bp.Stats()
+		require.Equal(t, int64(110), s.Max)
+		require.Equal(t, int64(60), s.EffectiveMax)
+
+		clock.Advance(time.Second * 5)
+		require.Equal(t, int64(110), bp.effectiveMax())
+		require.Equal(t, int64(0), bp.warmUpEndAt)
+		require.Equal(t, 50, acquire(bp))
+	})
+
+	main.Run("Exponential", func(t *testing.T) {
+		bp, clock := setUp(t, WarmUpExponential)
+
+		require.Equal(t, int64(10), bp.effectiveMax())
+		clock.Advance(time.Second * 5)
+		require.Equal(t, int64(33), bp.effectiveMax())
+		clock.Advance(time.Second * 5)
+		require.Equal(t, int64(110), bp.effectiveMax())
+	})
+
+	main.Run("FollowsMax", func(t *testing.T) {
+		bp, clock := setUp(t, WarmUpLinear)
+
+		clock.Advance(time.Second * 5)
+		bp.max = 210
+		require.Equal(t, int64(110), bp.effectiveMax())
+	})
+
+	main.Run("Restart", func(t *testing.T) {
+		bp, clock := setUp(t, WarmUpLinear)
+
+		clock.Advance(time.Second * 10)
+		require.Equal(t, int64(110), bp.effectiveMax())
+
+		bp.WarmUp()
+		require.Equal(t, int64(10), bp.effectiveMax())
+		clock.Advance(time.Second * 5)
+		require.Equal(t, int64(60), bp.effectiveMax())
+	})
+}